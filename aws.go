@@ -2,17 +2,26 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 var (
 	autoscalingSubsystem *autoscaling.AutoScaling
 	elasticComputeCloud  *ec2.EC2
+	lambdaService        *lambda.Lambda
+	simpleStorageService *s3.S3
+	secretsManager       *secretsmanager.SecretsManager
 	simpleSystemsManager *ssm.SSM
+	securityTokenService *sts.STS
 )
 
 func init() {
@@ -20,7 +29,11 @@ func init() {
 
 	autoscalingSubsystem = autoscaling.New(awsSession)
 	elasticComputeCloud = ec2.New(awsSession)
+	lambdaService = lambda.New(awsSession)
+	simpleStorageService = s3.New(awsSession)
+	secretsManager = secretsmanager.New(awsSession)
 	simpleSystemsManager = ssm.New(awsSession)
+	securityTokenService = sts.New(awsSession)
 }
 
 // returns ip addresses of instances in the "running" state from the first page of results
@@ -71,6 +84,58 @@ func listInstanceAddressesInGroup(group string) ([]string, error) {
 	return instanceAddresses, nil
 }
 
+func getObject(bucket, key string) ([]byte, error) {
+	goi := &s3.GetObjectInput{}
+	goi.SetBucket(bucket)
+	goi.SetKey(key)
+	goo, err := simpleStorageService.GetObject(goi)
+	if err != nil {
+		return nil, err
+	}
+	defer goo.Body.Close()
+	return ioutil.ReadAll(goo.Body)
+}
+
+// returns the private ip address of the instance
+func getInstanceAddress(instance string) (string, error) {
+	dii := &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{&instance},
+	}
+	dio, err := elasticComputeCloud.DescribeInstances(dii)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range dio.Reservations {
+		for _, i := range r.Instances {
+			if i.PrivateIpAddress != nil {
+				return *i.PrivateIpAddress, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("instance `%s` not found or has no private address", instance)
+}
+
+func completeLifecycleAction(group, hook, token, instance, result string) error {
+	cli := &autoscaling.CompleteLifecycleActionInput{}
+	cli.SetAutoScalingGroupName(group)
+	cli.SetLifecycleHookName(hook)
+	cli.SetLifecycleActionToken(token)
+	cli.SetInstanceId(instance)
+	cli.SetLifecycleActionResult(result)
+	_, err := autoscalingSubsystem.CompleteLifecycleAction(cli)
+	return err
+}
+
+func recordLifecycleActionHeartbeat(group, hook, token, instance string) error {
+	rhi := &autoscaling.RecordLifecycleActionHeartbeatInput{}
+	rhi.SetAutoScalingGroupName(group)
+	rhi.SetLifecycleHookName(hook)
+	rhi.SetLifecycleActionToken(token)
+	rhi.SetInstanceId(instance)
+	_, err := autoscalingSubsystem.RecordLifecycleActionHeartbeat(rhi)
+	return err
+}
+
 type parameterOptions struct {
 	Type           string
 	Description    string
@@ -93,6 +158,38 @@ func getParameter(name string) (string, int64, error) {
 	return *gpo.Parameter.Value, *gpo.Parameter.Version, nil
 }
 
+// returns the current version of a secret, binary secrets as their raw bytes
+func getSecretValue(id string) (string, error) {
+	gsi := &secretsmanager.GetSecretValueInput{}
+	gsi.SetSecretId(id)
+	gso, err := secretsManager.GetSecretValue(gsi)
+	if err != nil {
+		return "", err
+	}
+	if gso.SecretString != nil {
+		return *gso.SecretString, nil
+	}
+	return string(gso.SecretBinary), nil
+}
+
+// returns the decrypted values of all parameters beneath the given path, keyed by name
+func listParametersByPath(path string) (map[string]string, error) {
+	parameters := map[string]string{}
+
+	gpi := &ssm.GetParametersByPathInput{}
+	gpi.SetPath(path)
+	gpi.SetRecursive(true)
+	gpi.SetWithDecryption(true)
+	err := simpleSystemsManager.GetParametersByPathPages(gpi, func(gpo *ssm.GetParametersByPathOutput, last bool) bool {
+		for _, p := range gpo.Parameters {
+			parameters[*p.Name] = *p.Value
+		}
+		return true
+	})
+
+	return parameters, err
+}
+
 func putParameter(options *parameterOptions, name, value string) (int64, error) {
 	if options == nil {
 		options = &parameterOptions{}
@@ -127,3 +224,19 @@ func putParameter(options *parameterOptions, name, value string) (int64, error)
 	}
 	return *ppo.Version, nil
 }
+
+func deleteParameter(name string) error {
+	dpi := &ssm.DeleteParameterInput{}
+	dpi.SetName(name)
+	_, err := simpleSystemsManager.DeleteParameter(dpi)
+	return err
+}
+
+func invokeFunctionAsync(name string, payload []byte) error {
+	ii := &lambda.InvokeInput{}
+	ii.SetFunctionName(name)
+	ii.SetInvocationType(lambda.InvocationTypeEvent)
+	ii.SetPayload(payload)
+	_, err := lambdaService.Invoke(ii)
+	return err
+}
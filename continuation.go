@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+)
+
+const (
+	// CloudFormation waits an hour for a response, leave some of it to report the outcome
+	continuationBudget = 55 * time.Minute
+	// time reserved for re-invoking or responding before the Lambda times out
+	continuationMargin = 10 * time.Second
+)
+
+// continuableHandler is implemented by handlers whose create or update may outlast a single invocation. Continue
+// does as much as fits before `until`, resuming from the checkpoint it returned last time (nil on the first call).
+// Returning a non-nil checkpoint asks for the Lambda to re-invoke itself with the original event, in which case no
+// response is sent to CloudFormation yet and the error, if any, explains why the work is incomplete. It is reported
+// as the failure reason if the overall budget runs out before a checkpoint-less result. Delete is never continued.
+//
+// The function's role must be allowed to `lambda:InvokeFunction` itself.
+type continuableHandler interface {
+	customresource.Resource
+	Continue(evt *cloudformation.Event, ctx *lambdaruntime.Context, until time.Time, checkpoint json.RawMessage) (string, interface{}, json.RawMessage, error)
+}
+
+var continuableHandlers = map[string]continuableHandler{}
+
+// registerContinuable registers a handler with customresource, routing its creates and updates through Continue.
+func registerContinuable(name string, h continuableHandler) {
+	customresource.Register(name, h)
+	continuableHandlers["Custom::"+name] = h
+}
+
+type continuation struct {
+	Started    time.Time
+	Invocation int
+	Checkpoint json.RawMessage `json:",omitempty"`
+}
+
+type continuationEvent struct {
+	cloudformation.Event
+	Continuation *continuation `json:",omitempty"`
+}
+
+// the response document expected at the event's pre-signed `ResponseURL`
+type cloudformationResponse struct {
+	Status             string      `json:"Status"`
+	Reason             string      `json:"Reason,omitempty"`
+	PhysicalResourceID string      `json:"PhysicalResourceId"`
+	StackID            string      `json:"StackId"`
+	RequestID          string      `json:"RequestId"`
+	LogicalResourceID  string      `json:"LogicalResourceId"`
+	Data               interface{} `json:"Data,omitempty"`
+}
+
+func handleContinuable(raw json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	evt := &continuationEvent{}
+	if err := json.Unmarshal(raw, evt); err != nil {
+		return customresource.HandleLambda(raw, ctx)
+	}
+
+	h, ok := continuableHandlers[evt.ResourceType]
+	if !ok || evt.RequestType == "Delete" {
+		return customresource.HandleLambda(raw, ctx)
+	}
+
+	cont := evt.Continuation
+	if cont == nil {
+		cont = &continuation{Started: time.Now()}
+	}
+	cont.Invocation++
+
+	log.Printf("%s `%s` - invocation %d, started %s", evt.ResourceType, evt.LogicalResourceID, cont.Invocation, cont.Started.Format(time.RFC3339))
+
+	rid, data, checkpoint, err := h.Continue(&evt.Event, ctx, deadline(ctx, continuationMargin), cont.Checkpoint)
+	if checkpoint != nil {
+		if err != nil {
+			log.Printf("%s `%s` - incomplete: %v", evt.ResourceType, evt.LogicalResourceID, err)
+		}
+		if time.Since(cont.Started) > continuationBudget {
+			if err == nil {
+				err = errors.New("incomplete")
+			}
+			err = fmt.Errorf("gave up after %d invocations over %s: %v", cont.Invocation, time.Since(cont.Started).Round(time.Second), err)
+		} else if err = reinvoke(ctx, raw, cont, checkpoint); err == nil {
+			return nil, nil
+		}
+	}
+
+	return data, sendResponse(&evt.Event, ctx, rid, data, err)
+}
+
+// reinvoke asynchronously invokes the running function again with the original event and the checkpoint.
+func reinvoke(ctx *lambdaruntime.Context, raw json.RawMessage, cont *continuation, checkpoint json.RawMessage) error {
+	if ctx == nil || ctx.InvokedFunctionARN == "" {
+		return errors.New("unable to continue without the invoked function ARN")
+	}
+
+	payload := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	cont.Checkpoint = checkpoint
+	b, err := json.Marshal(cont)
+	if err != nil {
+		return err
+	}
+	payload["Continuation"] = b
+	if b, err = json.Marshal(payload); err != nil {
+		return err
+	}
+
+	log.Printf("Continuation - attempting invocation %d of `%s`", cont.Invocation+1, ctx.InvokedFunctionARN)
+	return invokeFunctionAsync(ctx.InvokedFunctionARN, b)
+}
+
+// sendResponse reports the outcome to CloudFormation, in place of customresource for continued handlers. It only
+// fails when the response could not be sent.
+func sendResponse(evt *cloudformation.Event, ctx *lambdaruntime.Context, rid string, data interface{}, err error) error {
+	resp := &cloudformationResponse{
+		Status:             "SUCCESS",
+		PhysicalResourceID: rid,
+		StackID:            evt.StackID,
+		RequestID:          evt.RequestID,
+		LogicalResourceID:  evt.LogicalResourceID,
+		Data:               data,
+	}
+	if err != nil {
+		log.Printf("%s `%s` - failed: %v", evt.ResourceType, evt.LogicalResourceID, err)
+		resp.Status = "FAILED"
+		resp.Reason = err.Error()
+		resp.Data = nil
+		if ctx != nil {
+			resp.Reason = fmt.Sprintf("%s (see %s)", resp.Reason, ctx.LogStreamName)
+		}
+	}
+
+	b, merr := json.Marshal(resp)
+	if merr != nil {
+		return merr
+	}
+
+	req, herr := http.NewRequest(http.MethodPut, evt.ResponseURL, bytes.NewReader(b))
+	if herr != nil {
+		return herr
+	}
+	// the pre-signed URL is signed without a content type
+	req.Header.Set("Content-Type", "")
+
+	log.Printf("%s `%s` - sending %s response", evt.ResourceType, evt.LogicalResourceID, resp.Status)
+	hresp, herr := http.DefaultClient.Do(req)
+	if herr != nil {
+		return herr
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status sending to CloudFormation: %s", hresp.Status)
+	}
+
+	// the failure is reported, so an async retry of the invocation would only repeat it
+	return nil
+}
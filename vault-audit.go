@@ -78,7 +78,7 @@ func (h *vaultAuditHandler) resource(evt *cloudformation.Event) (string, *vaultA
 		}
 	}
 
-	return rid, res, res.initWithTokenParameterOverride()
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
 }
 
 // Create is invoked when the resource is created.
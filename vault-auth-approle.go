@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+)
+
+func init() {
+	customresource.Register("VaultAppRole", new(vaultAppRoleHandler))
+}
+
+const (
+	appRoleDefaultPath = "approle"
+
+	appRoleParameterInfix = "Vault/AppRole"
+
+	appRoleDefaultRoleIDDescription   = "Vault AppRole RoleID"
+	appRoleDefaultSecretIDDescription = "Vault AppRole SecretID"
+)
+
+type vaultAppRoleHandler struct{}
+type vaultAppRoleResource struct {
+	vaultResource `json:"-"`
+
+	Name string `json:",omitempty"`
+	Path string `json:",omitempty"`
+
+	Policies           []string `json:",omitempty"`
+	BindSecretID       string   `json:",omitempty"`
+	BoundCIDRs         []string `json:",omitempty"`
+	SecretIDBoundCIDRs []string `json:",omitempty"`
+	SecretIDNumUses    string   `json:",omitempty"`
+	SecretIDTTL        string   `json:",omitempty"`
+	TokenNumUses       string   `json:",omitempty"`
+	TTL                string   `json:",omitempty"`
+	MaximumTTL         string   `json:",omitempty"`
+	Period             string   `json:",omitempty"`
+
+	SecretIDMetadata map[string]string `json:",omitempty"`
+
+	ParameterKey          string `json:",omitempty"`
+	RoleIDParameterName   string `json:",omitempty"`
+	SecretIDParameterName string `json:",omitempty"`
+
+	// changing the value of RotateSecretID issues a new secret-id on update
+	RotateSecretID string `json:",omitempty"`
+	DeleteRole     string `json:",omitempty"`
+
+	SecretIDAccessor string `json:",omitempty"`
+}
+
+func (h *vaultAppRoleHandler) properties(props json.RawMessage, stackID string) (*vaultAppRoleResource, error) {
+	res := &vaultAppRoleResource{}
+
+	if err := json.Unmarshal(props, res); err != nil {
+		return nil, err
+	}
+
+	if res.Name == "" {
+		return nil, errors.New("missing required resource property `Name`")
+	}
+
+	res.Path = strings.Trim(strings.TrimPrefix(res.Path, "auth/"), "/")
+	if res.Path == "" {
+		res.Path = appRoleDefaultPath
+	}
+
+	stcknm := strings.Split(stackID, "/")[1]
+	if res.RoleIDParameterName == "" {
+		res.RoleIDParameterName = fmt.Sprintf("/%s/%s/%s/RoleID", stcknm, appRoleParameterInfix, res.Name)
+	}
+	if res.SecretIDParameterName == "" {
+		res.SecretIDParameterName = fmt.Sprintf("/%s/%s/%s/SecretID", stcknm, appRoleParameterInfix, res.Name)
+	}
+	if res.RoleIDParameterName == res.SecretIDParameterName {
+		return nil, errors.New("RoleIDParameterName must be different than SecretIDParameterName")
+	}
+
+	bindSecretID := true
+	if res.BindSecretID != "" {
+		if b, err := strconv.ParseBool(res.BindSecretID); err != nil {
+			log.Printf("failed to parse `BindSecretID`: %v", err)
+		} else {
+			bindSecretID = b
+		}
+	}
+	res.BindSecretID = fmt.Sprint(bindSecretID)
+
+	deleteRole, err := strconv.ParseBool(res.DeleteRole)
+	if err != nil {
+		log.Printf("failed to parse `DeleteRole`: %v", err)
+	}
+	res.DeleteRole = fmt.Sprint(deleteRole)
+
+	secretIDNumUses, err := strconv.Atoi(res.SecretIDNumUses)
+	if err != nil {
+		log.Printf("failed to parse `SecretIDNumUses`: %v", err)
+	}
+	res.SecretIDNumUses = fmt.Sprint(secretIDNumUses)
+
+	tokenNumUses, err := strconv.Atoi(res.TokenNumUses)
+	if err != nil {
+		log.Printf("failed to parse `TokenNumUses`: %v", err)
+	}
+	res.TokenNumUses = fmt.Sprint(tokenNumUses)
+
+	for name, ttl := range map[string]string{
+		"SecretIDTTL": res.SecretIDTTL,
+		"TTL":         res.TTL,
+		"MaximumTTL":  res.MaximumTTL,
+		"Period":      res.Period,
+	} {
+		if err := validateTTL(name, ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (h *vaultAppRoleHandler) resource(evt *cloudformation.Event) (string, *vaultAppRoleResource, error) {
+	rid := resourceID(evt)
+
+	res, err := h.properties(evt.ResourceProperties, evt.StackID)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultAppRoleHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	log.Printf("Vault AppRole `%s` - attempting to create", res.rolePath())
+
+	if err = res.doWriteRole(); err != nil {
+		return rid, nil, err
+	}
+
+	// parameters left by an earlier resource of the same name hold nothing of use
+	return rid, res, res.doIssueSecretID(true)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultAppRoleHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	old, err := h.properties(evt.OldResourceProperties, evt.StackID)
+	if err != nil {
+		log.Printf("Vault AppRole `%s` - unable to parse previous properties: %v", res.rolePath(), err)
+		old = res
+	}
+
+	// a different role is a new resource, so CloudFormation deletes the old one along with its secret-id
+	if res.rolePath() != old.rolePath() {
+		rid = customresource.NewPhysicalResourceID(evt)
+	}
+
+	log.Printf("Vault AppRole `%s` - attempting to update", res.rolePath())
+
+	if err = res.doWriteRole(); err != nil {
+		return rid, nil, err
+	}
+
+	if res.rolePath() != old.rolePath() {
+		return rid, res, res.doIssueSecretID(res.SecretIDParameterName == old.SecretIDParameterName)
+	}
+
+	old.vaultResource = res.vaultResource
+	accessor, err := old.lookupSecretIDAccessor()
+	if err != nil {
+		log.Printf("Vault AppRole `%s` - unable to lookup previous secret-id: %v", old.rolePath(), err)
+	}
+
+	if res.RotateSecretID == old.RotateSecretID && res.SecretIDParameterName == old.SecretIDParameterName && res.BindSecretID == old.BindSecretID {
+		res.SecretIDAccessor = accessor
+		return rid, res, nil
+	}
+
+	log.Printf("Vault AppRole `%s` - attempting to rotate secret-id", res.rolePath())
+
+	if err = res.doIssueSecretID(res.SecretIDParameterName == old.SecretIDParameterName); err != nil {
+		return rid, nil, err
+	}
+
+	if accessor != "" {
+		if err = old.doDestroySecretIDAccessor(accessor); err != nil {
+			log.Printf("Vault AppRole `%s` - unable to destroy previous secret-id: %v", old.rolePath(), err)
+		}
+	}
+
+	for _, name := range []string{old.RoleIDParameterName, old.SecretIDParameterName} {
+		if name == res.RoleIDParameterName || (name == res.SecretIDParameterName && res.BindSecretID == "true") {
+			continue
+		}
+		if name == old.SecretIDParameterName && accessor == "" {
+			continue
+		}
+		log.Printf("Vault AppRole `%s` - attempting to delete previous parameter `%s`", old.rolePath(), name)
+		if err = deleteParameter(name); err != nil {
+			log.Printf("Vault AppRole `%s` - unable to delete previous parameter `%s`: %v", old.rolePath(), name, err)
+		}
+	}
+
+	return rid, res, nil
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultAppRoleHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+	if err == nil {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		res.doDeleteParameters()
+		if res.DeleteRole == "true" {
+			log.Printf("Vault AppRole `%s` - attempting delete", res.rolePath())
+			_, err = res.client.Logical().Delete(res.rolePath())
+		}
+	}
+
+	if err != nil {
+		log.Printf("Vault AppRole - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultAppRoleResource) rolePath() string {
+	return fmt.Sprintf("auth/%s/role/%s", res.Path, res.Name)
+}
+
+func (res *vaultAppRoleResource) doWriteRole() error {
+	data := map[string]interface{}{
+		"bind_secret_id":     res.BindSecretID == "true",
+		"secret_id_num_uses": res.SecretIDNumUses,
+		"token_num_uses":     res.TokenNumUses,
+		"policies":           strings.Join(res.Policies, ","),
+	}
+	for key, values := range map[string][]string{
+		"bound_cidr_list":       res.BoundCIDRs,
+		"secret_id_bound_cidrs": res.SecretIDBoundCIDRs,
+	} {
+		if len(values) > 0 {
+			data[key] = strings.Join(values, ",")
+		}
+	}
+	for key, value := range map[string]string{
+		"secret_id_ttl": res.SecretIDTTL,
+		"token_ttl":     res.TTL,
+		"token_max_ttl": res.MaximumTTL,
+		"period":        res.Period,
+	} {
+		if value != "" {
+			data[key] = value
+		}
+	}
+
+	if _, err := res.client.Logical().Write(res.rolePath(), data); err != nil {
+		return err
+	}
+
+	sec, err := res.client.Logical().Read(res.rolePath() + "/role-id")
+	if err != nil {
+		return err
+	}
+	if sec == nil || sec.Data == nil {
+		return fmt.Errorf("somehow got a nil role-id for `%s`", res.rolePath())
+	}
+	roleID, ok := sec.Data["role_id"].(string)
+	if !ok {
+		return fmt.Errorf("somehow got an invalid role-id for `%s`", res.rolePath())
+	}
+
+	rpo := &parameterOptions{
+		Description:   appRoleDefaultRoleIDDescription,
+		EncryptionKey: res.ParameterKey,
+		Overwrite:     true,
+	}
+	if _, err = putParameter(rpo, res.RoleIDParameterName, roleID); err != nil {
+		log.Printf("SSM PutParameter Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (res *vaultAppRoleResource) doIssueSecretID(overwrite bool) error {
+	if res.BindSecretID != "true" {
+		return nil
+	}
+
+	data := map[string]interface{}{}
+	if len(res.SecretIDMetadata) > 0 {
+		metadata, err := json.Marshal(res.SecretIDMetadata)
+		if err != nil {
+			return err
+		}
+		data["metadata"] = string(metadata)
+	}
+	if len(res.SecretIDBoundCIDRs) > 0 {
+		data["cidr_list"] = strings.Join(res.SecretIDBoundCIDRs, ",")
+	}
+
+	// DO NOT LOG THE RESPONSE
+	sec, err := res.client.Logical().Write(res.rolePath()+"/secret-id", data)
+	if err != nil {
+		return err
+	}
+	if sec == nil || sec.Data == nil {
+		return fmt.Errorf("somehow got a nil secret-id for `%s`", res.rolePath())
+	}
+	secretID, _ := sec.Data["secret_id"].(string)
+	accessor, _ := sec.Data["secret_id_accessor"].(string)
+	if secretID == "" || accessor == "" {
+		return fmt.Errorf("somehow got an invalid secret-id for `%s`", res.rolePath())
+	}
+
+	spo := &parameterOptions{
+		Description:   appRoleDefaultSecretIDDescription,
+		EncryptionKey: res.ParameterKey,
+		Overwrite:     overwrite,
+	}
+	if _, err = putParameter(spo, res.SecretIDParameterName, secretID); err != nil {
+		log.Printf("SSM PutParameter Error: %s", err)
+		return err
+	}
+
+	res.SecretIDAccessor = accessor
+
+	return nil
+}
+
+// lookupSecretIDAccessor resolves the accessor of the secret-id stored in the resource's parameter.
+func (res *vaultAppRoleResource) lookupSecretIDAccessor() (string, error) {
+	if res.BindSecretID != "true" {
+		return "", nil
+	}
+
+	secretID, _, err := getParameter(res.SecretIDParameterName)
+	if err != nil {
+		return "", err
+	}
+
+	sec, err := res.client.Logical().Write(res.rolePath()+"/secret-id/lookup", map[string]interface{}{
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if sec == nil || sec.Data == nil {
+		return "", fmt.Errorf("secret-id in `%s` not found", res.SecretIDParameterName)
+	}
+	accessor, _ := sec.Data["secret_id_accessor"].(string)
+
+	return accessor, nil
+}
+
+// doDeleteParameters destroys the secret-id and deletes the parameters, but only those holding this role's values,
+// so that a resource replacing this one under the same parameter names keeps its own.
+func (res *vaultAppRoleResource) doDeleteParameters() {
+	accessor, err := res.lookupSecretIDAccessor()
+	if err != nil {
+		log.Printf("Vault AppRole `%s` - unable to lookup secret-id: %v", res.rolePath(), err)
+	} else if accessor != "" {
+		if err = res.doDestroySecretIDAccessor(accessor); err != nil {
+			log.Printf("Vault AppRole `%s` - unable to destroy secret-id: %v", res.rolePath(), err)
+		}
+		log.Printf("Vault AppRole `%s` - attempting to delete parameter `%s`", res.rolePath(), res.SecretIDParameterName)
+		if err = deleteParameter(res.SecretIDParameterName); err != nil {
+			log.Printf("Vault AppRole `%s` - unable to delete parameter `%s`: %v", res.rolePath(), res.SecretIDParameterName, err)
+		}
+	}
+
+	sec, err := res.client.Logical().Read(res.rolePath() + "/role-id")
+	if err != nil || sec == nil || sec.Data == nil {
+		log.Printf("Vault AppRole `%s` - unable to read role-id, keeping parameter `%s`: %v", res.rolePath(), res.RoleIDParameterName, err)
+		return
+	}
+	if roleID, _, err := getParameter(res.RoleIDParameterName); err == nil && roleID == sec.Data["role_id"] {
+		log.Printf("Vault AppRole `%s` - attempting to delete parameter `%s`", res.rolePath(), res.RoleIDParameterName)
+		if err = deleteParameter(res.RoleIDParameterName); err != nil {
+			log.Printf("Vault AppRole `%s` - unable to delete parameter `%s`: %v", res.rolePath(), res.RoleIDParameterName, err)
+		}
+	}
+}
+
+func (res *vaultAppRoleResource) doDestroySecretIDAccessor(accessor string) error {
+	log.Printf("Vault AppRole `%s` - attempting to destroy secret-id accessor `%s`", res.rolePath(), accessor)
+	_, err := res.client.Logical().Write(res.rolePath()+"/secret-id-accessor/destroy", map[string]interface{}{
+		"secret_id_accessor": accessor,
+	})
+	return err
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+)
+
+func init() {
+	customresource.Register("VaultAwsAuthRole", new(vaultAwsAuthRoleHandler))
+}
+
+const (
+	awsAuthDefaultPath = "aws"
+
+	awsAuthTypeEC2 = "ec2"
+	awsAuthTypeIAM = "iam"
+)
+
+type vaultAwsAuthRoleHandler struct{}
+type vaultAwsAuthRoleResource struct {
+	vaultResource `json:"-"`
+
+	Name     string `json:",omitempty"`
+	Path     string `json:",omitempty"`
+	AuthType string `json:",omitempty"`
+
+	// more than one value per binding requires Vault 0.9.6 or later
+	BoundAmiID                 []string `json:",omitempty"`
+	BoundAccountID             []string `json:",omitempty"`
+	BoundRegion                []string `json:",omitempty"`
+	BoundVpcID                 []string `json:",omitempty"`
+	BoundSubnetID              []string `json:",omitempty"`
+	BoundIAMRoleARN            []string `json:",omitempty"`
+	BoundIAMInstanceProfileARN []string `json:",omitempty"`
+	BoundIAMPrincipalARN       []string `json:",omitempty"`
+
+	InferredEntityType  string `json:",omitempty"`
+	InferredAWSRegion   string `json:",omitempty"`
+	ResolveAWSUniqueIDs string `json:",omitempty"`
+
+	Policies   []string `json:",omitempty"`
+	TTL        string   `json:",omitempty"`
+	MaximumTTL string   `json:",omitempty"`
+	Period     string   `json:",omitempty"`
+
+	AllowInstanceMigration   string `json:",omitempty"`
+	DisallowReauthentication string `json:",omitempty"`
+
+	RoleID string `json:",omitempty"`
+}
+
+func (h *vaultAwsAuthRoleHandler) resource(evt *cloudformation.Event) (string, *vaultAwsAuthRoleResource, error) {
+	rid := resourceID(evt)
+	res := &vaultAwsAuthRoleResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	if res.Name == "" {
+		return rid, nil, errors.New("missing required resource property `Name`")
+	}
+
+	res.Path = strings.Trim(strings.TrimPrefix(res.Path, "auth/"), "/")
+	if res.Path == "" {
+		res.Path = awsAuthDefaultPath
+	}
+
+	if res.AuthType == "" {
+		res.AuthType = awsAuthTypeIAM
+	}
+
+	if err := res.validate(); err != nil {
+		return rid, nil, err
+	}
+
+	resolveAWSUniqueIDs := true
+	if res.ResolveAWSUniqueIDs != "" {
+		if b, err := strconv.ParseBool(res.ResolveAWSUniqueIDs); err != nil {
+			log.Printf("failed to parse `ResolveAWSUniqueIDs`: %v", err)
+		} else {
+			resolveAWSUniqueIDs = b
+		}
+	}
+	res.ResolveAWSUniqueIDs = fmt.Sprint(resolveAWSUniqueIDs)
+
+	allowInstanceMigration, err := strconv.ParseBool(res.AllowInstanceMigration)
+	if err != nil {
+		log.Printf("failed to parse `AllowInstanceMigration`: %v", err)
+	}
+	res.AllowInstanceMigration = fmt.Sprint(allowInstanceMigration)
+
+	disallowReauthentication, err := strconv.ParseBool(res.DisallowReauthentication)
+	if err != nil {
+		log.Printf("failed to parse `DisallowReauthentication`: %v", err)
+	}
+	res.DisallowReauthentication = fmt.Sprint(disallowReauthentication)
+
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+func (res *vaultAwsAuthRoleResource) validate() error {
+	ec2Bound := len(res.BoundAmiID) > 0 || len(res.BoundAccountID) > 0 || len(res.BoundRegion) > 0 ||
+		len(res.BoundVpcID) > 0 || len(res.BoundSubnetID) > 0 || len(res.BoundIAMRoleARN) > 0 ||
+		len(res.BoundIAMInstanceProfileARN) > 0
+
+	switch res.AuthType {
+	case awsAuthTypeIAM:
+		if len(res.BoundIAMPrincipalARN) == 0 && res.InferredEntityType == "" {
+			return errors.New("`AuthType` iam requires `BoundIAMPrincipalARN` or `InferredEntityType`")
+		}
+		if ec2Bound && res.InferredEntityType == "" {
+			return errors.New("`AuthType` iam with ec2 bindings requires `InferredEntityType`")
+		}
+		if res.InferredEntityType != "" && res.InferredEntityType != "ec2_instance" {
+			return fmt.Errorf("invalid `InferredEntityType`: %s", res.InferredEntityType)
+		}
+		if res.InferredEntityType != "" && res.InferredAWSRegion == "" {
+			return errors.New("`InferredEntityType` requires `InferredAWSRegion`")
+		}
+	case awsAuthTypeEC2:
+		if !ec2Bound {
+			return errors.New("`AuthType` ec2 requires at least one of the ec2 `Bound*` properties")
+		}
+		if len(res.BoundIAMPrincipalARN) > 0 {
+			return errors.New("`BoundIAMPrincipalARN` is only valid with `AuthType` iam")
+		}
+		if res.InferredEntityType != "" || res.InferredAWSRegion != "" {
+			return errors.New("`InferredEntityType` and `InferredAWSRegion` are only valid with `AuthType` iam")
+		}
+	default:
+		return fmt.Errorf("invalid `AuthType`: %s", res.AuthType)
+	}
+
+	for name, arns := range map[string][]string{
+		"BoundIAMRoleARN":            res.BoundIAMRoleARN,
+		"BoundIAMInstanceProfileARN": res.BoundIAMInstanceProfileARN,
+		"BoundIAMPrincipalARN":       res.BoundIAMPrincipalARN,
+	} {
+		for _, arn := range arns {
+			if !strings.HasPrefix(arn, "arn:") {
+				return fmt.Errorf("invalid `%s`: %s", name, arn)
+			}
+		}
+	}
+
+	for name, ttl := range map[string]string{
+		"TTL":        res.TTL,
+		"MaximumTTL": res.MaximumTTL,
+		"Period":     res.Period,
+	} {
+		if err := validateTTL(name, ttl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultAwsAuthRoleHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	return h.Update(evt, ctx)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultAwsAuthRoleHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	if evt.RequestType == "Update" {
+		old := &vaultAwsAuthRoleResource{}
+		if err = json.Unmarshal(evt.OldResourceProperties, old); err != nil {
+			return rid, nil, err
+		}
+		old.Path = strings.Trim(strings.TrimPrefix(old.Path, "auth/"), "/")
+		if old.Path == "" {
+			old.Path = awsAuthDefaultPath
+		}
+		// a different role is a new resource, so CloudFormation deletes the old one and its bindings
+		if res.rolePath() != old.rolePath() {
+			rid = customresource.NewPhysicalResourceID(evt)
+		}
+	}
+
+	log.Printf("Vault AWS Auth Role `%s` - attempting %s", res.rolePath(), strings.ToLower(evt.RequestType))
+
+	if _, err = res.client.Logical().Write(res.rolePath(), res.data()); err != nil {
+		return rid, nil, err
+	}
+
+	sec, err := res.client.Logical().Read(res.rolePath())
+	if err != nil {
+		return rid, nil, err
+	}
+	if sec != nil && sec.Data != nil {
+		if roleID, ok := sec.Data["role_id"].(string); ok {
+			res.RoleID = roleID
+		}
+	}
+
+	return rid, res, nil
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultAwsAuthRoleHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+	if err == nil {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		log.Printf("Vault AWS Auth Role `%s` - attempting delete", res.rolePath())
+		_, err = res.client.Logical().Delete(res.rolePath())
+	}
+
+	if err != nil {
+		log.Printf("Vault AWS Auth Role - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultAwsAuthRoleResource) rolePath() string {
+	return fmt.Sprintf("auth/%s/role/%s", res.Path, res.Name)
+}
+
+func (res *vaultAwsAuthRoleResource) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"auth_type":                 res.AuthType,
+		"resolve_aws_unique_ids":    res.ResolveAWSUniqueIDs == "true",
+		"allow_instance_migration":  res.AllowInstanceMigration == "true",
+		"disallow_reauthentication": res.DisallowReauthentication == "true",
+		"policies":                  strings.Join(res.Policies, ","),
+	}
+
+	// bindings are sent comma-delimited, servers before Vault 0.9.6 take that as a single literal value
+	for key, values := range map[string][]string{
+		"bound_ami_id":                   res.BoundAmiID,
+		"bound_account_id":               res.BoundAccountID,
+		"bound_region":                   res.BoundRegion,
+		"bound_vpc_id":                   res.BoundVpcID,
+		"bound_subnet_id":                res.BoundSubnetID,
+		"bound_iam_role_arn":             res.BoundIAMRoleARN,
+		"bound_iam_instance_profile_arn": res.BoundIAMInstanceProfileARN,
+		"bound_iam_principal_arn":        res.BoundIAMPrincipalARN,
+	} {
+		if len(values) > 0 {
+			data[key] = strings.Join(values, ",")
+		}
+	}
+
+	for key, value := range map[string]string{
+		"inferred_entity_type": res.InferredEntityType,
+		"inferred_aws_region":  res.InferredAWSRegion,
+		"ttl":                  res.TTL,
+		"max_ttl":              res.MaximumTTL,
+		"period":               res.Period,
+	} {
+		if value != "" {
+			data[key] = value
+		}
+	}
+
+	return data
+}
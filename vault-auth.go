@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+func init() {
+	customresource.Register("VaultAuthBackend", new(vaultAuthHandler))
+}
+
+const (
+	// the token auth backend is always mounted and cannot be disabled
+	authTypeToken = "token"
+)
+
+type vaultAuthHandler struct{}
+type vaultAuthResource struct {
+	vaultResource `json:"-"`
+
+	Type              string `json:",omitempty"`
+	Path              string `json:",omitempty"`
+	Description       string `json:",omitempty"`
+	Local             string `json:",omitempty"`
+	ListingVisibility string `json:",omitempty"`
+
+	DefaultLeaseTTL string `json:",omitempty"`
+	MaximumLeaseTTL string `json:",omitempty"`
+
+	Disable string `json:",omitempty"`
+
+	Accessor string `json:",omitempty"`
+}
+
+func (h *vaultAuthHandler) resource(evt *cloudformation.Event) (string, *vaultAuthResource, error) {
+	rid := resourceID(evt)
+	res := &vaultAuthResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	if res.Type == "" && res.Path == "" {
+		return rid, nil, errors.New("missing required resource property, one of `Type` or `Path`")
+	}
+
+	disable, err := strconv.ParseBool(res.Disable)
+	if err != nil {
+		log.Printf("failed to parse `Disable`: %v", err)
+	}
+	res.Disable = fmt.Sprint(disable)
+
+	local, err := strconv.ParseBool(res.Local)
+	if err != nil {
+		log.Printf("failed to parse `Local`: %v", err)
+	}
+	res.Local = fmt.Sprint(local)
+
+	res.normalizePath()
+
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+func (res *vaultAuthResource) normalizePath() {
+	res.Path = strings.TrimPrefix(res.Path, "auth/")
+	if res.Path == "" {
+		res.Path = res.Type
+	}
+	if !strings.HasSuffix(res.Path, "/") {
+		res.Path += "/"
+	}
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultAuthHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	return h.Update(evt, ctx)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultAuthHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	if evt.RequestType == "Update" {
+		old := &vaultAuthResource{}
+		if err = json.Unmarshal(evt.OldResourceProperties, old); err != nil {
+			return rid, nil, err
+		}
+		old.normalizePath()
+		oldLocal, _ := strconv.ParseBool(old.Local)
+
+		switch {
+		case old.Path != res.Path:
+			// the old backend is disabled when CloudFormation deletes the replaced resource
+			rid = customresource.NewPhysicalResourceID(evt)
+		case (res.Type != "" && old.Type != "" && res.Type != old.Type) || res.Local != fmt.Sprint(oldLocal):
+			return rid, nil, fmt.Errorf("Vault Auth `%s` - changing `Type` or `Local` replaces the backend, which requires a new `Path`", res.Path)
+		}
+	}
+
+	if res.Disable == "true" {
+		return rid, res, res.doDisable()
+	}
+
+	auths, err := res.client.Sys().ListAuth()
+	if err != nil {
+		return rid, nil, err
+	}
+
+	if auth, ok := auths[res.Path]; ok {
+		if res.Type != "" && res.Type != auth.Type {
+			return rid, nil, fmt.Errorf("Vault Auth `%s` exists with type `%s`, not `%s`", res.Path, auth.Type, res.Type)
+		}
+		if res.Local != fmt.Sprint(auth.Local) {
+			return rid, nil, fmt.Errorf("Vault Auth `%s` exists with local `%t`, not `%s`", res.Path, auth.Local, res.Local)
+		}
+		res.Type = auth.Type
+		res.Accessor = auth.Accessor
+		log.Printf("Vault Auth `%s` exists: Type:%s, Local:%s, Description:%s", res.Path, res.Type, res.Local, auth.Description)
+		if res.Description != "" && res.Description != auth.Description {
+			if err = res.doDescribe(); err != nil {
+				return rid, nil, err
+			}
+		}
+		return rid, res, res.doTune()
+	}
+
+	if res.Type == "" {
+		return rid, nil, fmt.Errorf("Vault Auth `%s` not found and no `Type` specified", res.Path)
+	}
+
+	if err = res.doEnable(); err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.doTune()
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultAuthHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+
+	if err == nil {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		err = res.doDisable()
+	}
+
+	if err != nil {
+		log.Printf("Vault Auth - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultAuthResource) doEnable() error {
+	opts := vaultapi.EnableAuthOptions{
+		Type:        res.Type,
+		Description: res.Description,
+		Local:       res.Local == "true",
+		Config: vaultapi.AuthConfigInput{
+			DefaultLeaseTTL:   res.DefaultLeaseTTL,
+			MaxLeaseTTL:       res.MaximumLeaseTTL,
+			ListingVisibility: res.ListingVisibility,
+		},
+	}
+
+	log.Printf("Vault Auth `%s` enable: Type:%s, Local:%s, Description:%s", res.Path, res.Type, res.Local, res.Description)
+	if err := res.client.Sys().EnableAuthWithOptions(res.Path, &opts); err != nil {
+		return err
+	}
+
+	auths, err := res.client.Sys().ListAuth()
+	if err != nil {
+		return err
+	}
+	if auth, ok := auths[res.Path]; ok {
+		res.Accessor = auth.Accessor
+	}
+
+	return nil
+}
+
+func (res *vaultAuthResource) doTune() error {
+	mount := "auth/" + res.Path
+	mci := vaultapi.MountConfigInput{
+		DefaultLeaseTTL:   res.DefaultLeaseTTL,
+		MaxLeaseTTL:       res.MaximumLeaseTTL,
+		ListingVisibility: res.ListingVisibility,
+	}
+	log.Printf("Vault Auth `%s` - attempting to tune", res.Path)
+	if err := res.client.Sys().TuneMount(mount, mci); err != nil {
+		return err
+	}
+	mco, err := res.client.Sys().MountConfig(mount)
+	if err != nil {
+		return err
+	}
+	res.DefaultLeaseTTL = fmt.Sprint(mco.DefaultLeaseTTL)
+	res.MaximumLeaseTTL = fmt.Sprint(mco.MaxLeaseTTL)
+	res.ListingVisibility = mco.ListingVisibility
+
+	return nil
+}
+
+// doDescribe tunes the description, which the tune options of this client do not carry.
+func (res *vaultAuthResource) doDescribe() error {
+	log.Printf("Vault Auth `%s` - attempting to tune description", res.Path)
+	_, err := res.client.Logical().Write("sys/auth/"+res.Path+"tune", map[string]interface{}{
+		"description": res.Description,
+	})
+	return err
+}
+
+func (res *vaultAuthResource) doDisable() error {
+	if res.Path == authTypeToken+"/" {
+		return fmt.Errorf("`%s` cannot be disabled", res.Path)
+	}
+	log.Printf("Vault Auth `%s` disable", res.Path)
+	return res.client.Sys().DisableAuth(res.Path)
+}
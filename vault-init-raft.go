@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// raftOrder puts initialized nodes first, so there is a leader to join by the time uninitialized nodes come up. It
+// also reports whether every node responded uninitialized, the only state in which a new cluster may be initialized.
+func (res *vaultInitResource) raftOrder(addrs []string) ([]string, bool) {
+	initialized := make(map[string]bool, len(addrs))
+	uninitialized := true
+	for _, addr := range addrs {
+		client, err := res.newClientForAddress(addr)
+		if err != nil {
+			uninitialized = false
+			continue
+		}
+		client.SetMaxRetries(0)
+		client.SetClientTimeout(initHealthTimeout)
+		health, err := client.Sys().Health()
+		if err != nil {
+			log.Printf("Vault Init `%s` - Health: %+v", addr, err)
+			uninitialized = false
+			continue
+		}
+		initialized[addr] = health.Initialized
+		uninitialized = uninitialized && !health.Initialized
+	}
+
+	ordered := append([]string{}, addrs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return initialized[ordered[i]] && !initialized[ordered[j]]
+	})
+
+	return ordered, uninitialized
+}
+
+// raftInitialized reports whether any node has been seen initialized, in which case none may be initialized again.
+func (res *vaultInitResource) raftInitialized(cp *initCheckpoint) bool {
+	for _, state := range cp.Nodes {
+		if state.Initialized {
+			return true
+		}
+	}
+	return false
+}
+
+// doRaftJoin asks the uninitialized node at the client's address to join the leader's cluster.
+func (res *vaultInitResource) doRaftJoin(client *vaultapi.Client, addr, leader string) error {
+	data := map[string]interface{}{
+		"leader_api_addr": leader,
+	}
+	if res.RaftLeaderCACert != "" {
+		cert, err := resolveReference("CA certificate", res.RaftLeaderCACert)
+		if err != nil {
+			return err
+		}
+		data["leader_ca_cert"] = cert
+	}
+
+	log.Printf("Vault Init `%s` - attempting to join raft cluster at `%s`", addr, leader)
+	sec, err := client.Logical().Write("sys/storage/raft/join", data)
+	if err != nil {
+		return err
+	}
+	if sec != nil && sec.Data["joined"] == false {
+		return fmt.Errorf("`%s` was not joined to `%s`", addr, leader)
+	}
+
+	return nil
+}
+
+// doRaftPeers checks that every healthy node in the group is a raft peer, and removes peers whose addresses are no
+// longer among the group's instances. It authenticates with the stored root token, or with the function's own login
+// when that is unavailable, such as when revoked after bootstrap or encrypted to `RootTokenPGPKey`.
+func (res *vaultInitResource) doRaftPeers(instances []string, group map[string]string, cp *initCheckpoint) error {
+	if cp.LeaderAddress == "" {
+		log.Printf("Vault Init - Raft Peers: skipping, no unsealed node to read the configuration from")
+		return nil
+	}
+
+	token, _, err := getParameter(res.RootTokenParameterName)
+	if err != nil || res.RootTokenPGPKey != "" {
+		if res.RootTokenPGPKey != "" {
+			err = errors.New("encrypted to `RootTokenPGPKey`")
+		}
+		log.Printf("Vault Init - Raft Peers: root token unavailable (%v), attempting with the function's own login", err)
+		if lerr := res.initWithLogin(nil); lerr != nil {
+			return fmt.Errorf("unable to verify raft peers, root token unavailable (%v) and login failed: %v", err, lerr)
+		}
+		if token = res.client.Token(); token == "" {
+			return fmt.Errorf("unable to verify raft peers, root token unavailable (%v) and no token of the function's own", err)
+		}
+	}
+
+	client, err := res.newClientForAddress(cp.LeaderAddress)
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+
+	sec, err := client.Logical().Read("sys/storage/raft/configuration")
+	if err != nil {
+		return err
+	}
+	if sec == nil || sec.Data == nil {
+		return fmt.Errorf("no raft configuration at `%s`", cp.LeaderAddress)
+	}
+	config, _ := sec.Data["config"].(map[string]interface{})
+	servers, _ := config["servers"].([]interface{})
+
+	// peers are known by their cluster addresses, which share the instance's ip address with the api address
+	peers := make(map[string]string, len(servers))
+	leaders := map[string]bool{}
+	byIP := true
+	for _, s := range servers {
+		server, _ := s.(map[string]interface{})
+		id, _ := server["node_id"].(string)
+		address, _ := server["address"].(string)
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		peers[host] = id
+		byIP = byIP && net.ParseIP(host) != nil
+		if leader, _ := server["leader"].(bool); leader {
+			leaders[host] = true
+		}
+	}
+	log.Printf("Vault Init - Raft Peers: %v", peers)
+
+	current := make(map[string]bool, len(instances))
+	for _, iip := range instances {
+		current[iip] = true
+	}
+
+	for host, id := range peers {
+		// only peers addressed by ip can be told apart from the group's instances
+		if current[host] || leaders[host] || net.ParseIP(host) == nil {
+			continue
+		}
+		log.Printf("Vault Init - Raft Peers: attempting to remove dead peer `%s` at %s", id, host)
+		if _, err = client.Logical().Write("sys/storage/raft/remove-peer", map[string]interface{}{
+			"server_id": id,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if !byIP {
+		log.Printf("Vault Init - Raft Peers: skipping verification, peers are not addressed by ip")
+		return nil
+	}
+
+	missing := []string{}
+	for iip := range group {
+		if _, ok := peers[iip]; !ok {
+			missing = append(missing, iip)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("not yet raft peers: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
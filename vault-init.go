@@ -5,24 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
 	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
 	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
 	vaultapi "github.com/hashicorp/vault/api"
 )
 
 func init() {
-	customresource.Register("VaultInit", new(vaultInitHandler))
+	registerContinuable("VaultInit", new(vaultInitHandler))
 }
 
 const (
 	initDefaultScheme = "http"
 	initDefaultPort   = "8200"
 
+	// time reserved for initializing and unsealing once the group is healthy
+	initDeadlineMargin   = 30 * time.Second
+	initHealthTimeout    = 10 * time.Second
+	initHealthBackoff    = 1 * time.Second
+	initHealthBackoffMax = 30 * time.Second
+	// time needed to initialize or unseal a single node
+	initNodeBudget = 15 * time.Second
+
 	initDefaultRootTokenDescription = "Vault Root Token"
 	initDefaultRootTokenSuffix      = "Token/Root"
 
@@ -31,12 +40,55 @@ const (
 
 	initDefaultSecretShares    = 5
 	initDefaultSecretThreshold = 3
+
+	initDefaultRecoveryShareDescription = "Vault Recovery Key"
+	initDefaultRecoveryShareSuffix      = "Secret/Recovery"
+
+	initDefaultRecoveryShares    = 5
+	initDefaultRecoveryThreshold = 3
+
+	// stored beside the numbered shares, `true` when they are encrypted to PGP keys
+	shareParameterPGPSuffix = "/PGPEncrypted"
+
+	storageTypeRaft = "raft"
+
+	sealTypeShamir = "shamir"
+	// reported by servers with a recovery seal that predate seal types
+	sealTypeAuto = "auto"
 )
 
 var (
 	errSupposedlyUnpossibleInitilizationStateMismatch = fmt.Errorf("supposedly unpossible initialization state mismatch with group")
 )
 
+// initCheckpoint records the progress of an initialization that continues across invocations.
+type initCheckpoint struct {
+	Initialized bool `json:",omitempty"`
+	// address of the node whose root token awaits revocation
+	RootAddress string `json:",omitempty"`
+	// address of the raft node the others join
+	LeaderAddress string `json:",omitempty"`
+	// seal type detected by an earlier invocation, which decides whether the shards are read back
+	SealType string                `json:",omitempty"`
+	Nodes    map[string]*nodeState `json:",omitempty"`
+}
+
+// vaultInitAttributes are facts about the cluster and where its secrets are stored, none of them secret. They are
+// kept apart from the resource's properties, which would crowd the limited size of the response.
+type vaultInitAttributes struct {
+	RootTokenParameterName     string `json:",omitempty"`
+	SecretShareParameterName   string `json:",omitempty"`
+	RecoveryShareParameterName string `json:",omitempty"`
+	SealType                   string `json:",omitempty"`
+
+	Version              string `json:",omitempty"`
+	ClusterName          string `json:",omitempty"`
+	ClusterID            string `json:",omitempty"`
+	LeaderAddress        string `json:",omitempty"`
+	InitializedNodeCount string
+	UnsealedNodeCount    string
+}
+
 type vaultInitHandler struct{}
 type vaultInitResource struct {
 	vaultResource `json:"-"`
@@ -54,6 +106,28 @@ type vaultInitResource struct {
 	SecretShares    string `json:",omitempty"`
 	SecretThreshold string `json:",omitempty"`
 	ShouldUnseal    string `json:",omitempty"`
+
+	// public keys as base64, `ssm:/parameter/name` or `s3://bucket/key` references
+	PGPKeys         []string `json:",omitempty"`
+	RootTokenPGPKey string   `json:",omitempty"`
+
+	// detected from the seal status when not specified, anything other than `shamir` is auto-unsealed
+	SealType string `json:",omitempty"`
+
+	RecoveryShareEncryptionKey string `json:",omitempty"`
+	RecoveryShareParameterName string `json:",omitempty"`
+
+	RecoveryShares    string   `json:",omitempty"`
+	RecoveryThreshold string   `json:",omitempty"`
+	RecoveryPGPKeys   []string `json:",omitempty"`
+
+	// revoke the initial root token instead of storing it, see Custom::VaultGenerateRoot
+	RevokeRootAfterBootstrap string `json:",omitempty"`
+
+	// `raft` for integrated storage, where nodes other than the first join it rather than share its storage
+	StorageType string `json:",omitempty"`
+	// PEM encoded, `ssm:/parameter/name` or `s3://bucket/key` reference
+	RaftLeaderCACert string `json:",omitempty"`
 }
 
 func (h *vaultInitHandler) resource(evt *cloudformation.Event) (string, *vaultInitResource, error) {
@@ -73,7 +147,7 @@ func (h *vaultInitHandler) resource(evt *cloudformation.Event) (string, *vaultIn
 	}
 
 	if res.ServerPort == "" {
-		res.ServerScheme = initDefaultPort
+		res.ServerPort = initDefaultPort
 	}
 
 	stcknm := strings.Split(evt.StackID, "/")[1]
@@ -86,10 +160,22 @@ func (h *vaultInitHandler) resource(evt *cloudformation.Event) (string, *vaultIn
 		res.SecretShareParameterName = fmt.Sprintf("/%s/Vault/%s", stcknm, initDefaultSecretShareSuffix)
 	}
 
+	if res.RecoveryShareParameterName == "" {
+		res.RecoveryShareParameterName = fmt.Sprintf("/%s/Vault/%s", stcknm, initDefaultRecoveryShareSuffix)
+	}
+
+	if res.RecoveryShareEncryptionKey == "" {
+		res.RecoveryShareEncryptionKey = res.SecretShareEncryptionKey
+	}
+
 	if res.RootTokenParameterName == res.SecretShareParameterName {
 		return rid, nil, errors.New("RootTokenParameterName must be different than SecretShareParameterName")
 	}
 
+	if res.RootTokenParameterName == res.RecoveryShareParameterName || res.SecretShareParameterName == res.RecoveryShareParameterName {
+		return rid, nil, errors.New("RecoveryShareParameterName must be different than RootTokenParameterName and SecretShareParameterName")
+	}
+
 	secretShares, err := strconv.Atoi(res.SecretShares)
 	if err != nil {
 		log.Printf("failed to parse `SecretShares`: %v", err)
@@ -107,6 +193,52 @@ func (h *vaultInitHandler) resource(evt *cloudformation.Event) (string, *vaultIn
 	}
 	res.SecretThreshold = fmt.Sprint(secretThreshold)
 
+	if len(res.PGPKeys) > 0 && len(res.PGPKeys) != secretShares {
+		return rid, nil, fmt.Errorf("number of `PGPKeys` (%d) must equal `SecretShares` (%d)", len(res.PGPKeys), secretShares)
+	}
+
+	recoveryShares, err := strconv.Atoi(res.RecoveryShares)
+	if err != nil {
+		log.Printf("failed to parse `RecoveryShares`: %v", err)
+		recoveryShares = initDefaultRecoveryShares
+	}
+	res.RecoveryShares = fmt.Sprint(recoveryShares)
+
+	recoveryThreshold, err := strconv.Atoi(res.RecoveryThreshold)
+	if err != nil {
+		log.Printf("failed to parse `RecoveryThreshold`: %v", err)
+		recoveryThreshold = initDefaultRecoveryThreshold
+	}
+	if recoveryThreshold > recoveryShares {
+		recoveryThreshold = recoveryShares
+	}
+	res.RecoveryThreshold = fmt.Sprint(recoveryThreshold)
+
+	if len(res.RecoveryPGPKeys) > 0 && len(res.RecoveryPGPKeys) != recoveryShares {
+		return rid, nil, fmt.Errorf("number of `RecoveryPGPKeys` (%d) must equal `RecoveryShares` (%d)", len(res.RecoveryPGPKeys), recoveryShares)
+	}
+
+	revokeRoot, err := strconv.ParseBool(res.RevokeRootAfterBootstrap)
+	if err != nil {
+		log.Printf("failed to parse `RevokeRootAfterBootstrap`: %v", err)
+	}
+	res.RevokeRootAfterBootstrap = fmt.Sprint(revokeRoot)
+
+	if revokeRoot && res.RootTokenPGPKey != "" {
+		return rid, nil, errors.New("`RevokeRootAfterBootstrap` cannot revoke a root token encrypted to `RootTokenPGPKey`")
+	}
+	if revokeRoot && (res.SealType == "" || res.SealType == sealTypeShamir) && (res.ShouldUnseal != "true" || len(res.PGPKeys) > 0) {
+		log.Printf("`RevokeRootAfterBootstrap` requires the servers to be unsealed, which may not happen without `ShouldUnseal` and unencrypted shares")
+	}
+
+	if res.StorageType != "" && res.StorageType != storageTypeRaft {
+		return rid, nil, fmt.Errorf("unsupported `StorageType` %s", res.StorageType)
+	}
+
+	if res.SealType != "" && res.SealType != sealTypeShamir && len(res.PGPKeys) > 0 {
+		return rid, nil, fmt.Errorf("`PGPKeys` are not applicable to `SealType` %s, use `RecoveryPGPKeys`", res.SealType)
+	}
+
 	return rid, res, res.init()
 }
 
@@ -115,25 +247,51 @@ func (h *vaultInitHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.
 	return h.Update(evt, ctx)
 }
 
-// Update is invoked when the resource is updated.
+// Update is invoked when the resource is updated, only when not handled by Continue.
 func (h *vaultInitHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, data, checkpoint, err := h.Continue(evt, ctx, deadline(ctx, initDeadlineMargin), nil)
+	if checkpoint != nil && err == nil {
+		err = errors.New("deadline exceeded before initialization completed")
+	}
+	return rid, data, err
+}
+
+// Continue initializes and unseals as much of the group as the deadline allows, see continuableHandler.
+func (h *vaultInitHandler) Continue(evt *cloudformation.Event, ctx *lambdaruntime.Context, until time.Time, checkpoint json.RawMessage) (string, interface{}, json.RawMessage, error) {
 	rid, res, err := h.resource(evt)
 	if err != nil {
-		return rid, nil, err
+		return rid, nil, nil, err
 	}
 
-	// override default retry and timeout configuration to give us more control over timing
-	res.client.SetMaxRetries(0)
-	res.client.SetClientTimeout(10 * time.Second)
+	cp := &initCheckpoint{}
+	if checkpoint != nil {
+		if err = json.Unmarshal(checkpoint, cp); err != nil {
+			return rid, nil, nil, err
+		}
+	}
+	if cp.Nodes == nil {
+		cp.Nodes = map[string]*nodeState{}
+	}
+	if res.SealType == "" {
+		res.SealType = cp.SealType
+	}
+	suspend := func(reason error) (string, interface{}, json.RawMessage, error) {
+		cp.SealType = res.SealType
+		b, err := json.Marshal(cp)
+		if err != nil {
+			return rid, nil, nil, err
+		}
+		return rid, nil, b, reason
+	}
 
 	instanceAddr, err := listInstanceAddressesInGroup(res.ServerGroup)
 	if err != nil {
-		return rid, nil, err
+		return rid, nil, nil, err
 	}
 
 	instanceCount := len(instanceAddr)
 	if instanceCount == 0 {
-		return rid, nil, fmt.Errorf("no suitable instances found in group `%s`", res.ServerGroup)
+		return rid, nil, nil, fmt.Errorf("no suitable instances found in group `%s`", res.ServerGroup)
 	}
 
 	// translate ip addresses to vault addresses
@@ -142,25 +300,65 @@ func (h *vaultInitHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.
 		vaultAddr[iip] = fmt.Sprintf("%s://%s:%s", res.ServerScheme, iip, res.ServerPort)
 	}
 
-	for err := res.doHealth(vaultAddr); len(err) > 0; err = res.doHealth(vaultAddr) {
+	backoff := initHealthBackoff
+	for {
+		initialized, err := res.doHealth(vaultAddr)
+		if len(err) == 0 {
+			break
+		}
 		// bail on fatal error condition
 		for _, e := range err {
 			if e == errSupposedlyUnpossibleInitilizationStateMismatch {
-				return rid, nil, e
+				return rid, nil, nil, e
 			}
 		}
-		// if every vault is erroring out, lets wait then retry
-		if len(err) == len(vaultAddr) {
-			time.Sleep(5 * time.Second)
-		} else { // zero out the bad apples
+		// a raft node failing health may hold a cluster of its own, so a new one waits for every node to respond
+		raftPending := res.StorageType == storageTypeRaft && !initialized && !cp.Initialized && cp.LeaderAddress == "" && !res.raftInitialized(cp)
+		// if some vaults respond, zero out the bad apples and carry on without them
+		if len(err) < len(vaultAddr) && !raftPending {
 			for i := range err {
 				delete(vaultAddr, i)
 			}
 			break
 		}
+		// if every vault is erroring out, lets wait then retry while there is time left to initialize
+		if time.Now().Add(backoff).After(until) {
+			return suspend(healthSummary(res.ServerGroup, vaultAddr, err))
+		}
+		if raftPending && len(err) < len(vaultAddr) {
+			log.Printf("Vault Init - not every vault in group `%s` responded to initialize raft, retrying in %s", res.ServerGroup, backoff)
+		} else {
+			log.Printf("Vault Init - no vault in group `%s` is healthy, retrying in %s", res.ServerGroup, backoff)
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > initHealthBackoffMax {
+			backoff = initHealthBackoffMax
+		}
 	}
 
-	return rid, res, res.doInitialize(vaultAddr)
+	done, err := res.doInitialize(vaultAddr, cp, until)
+	if err != nil {
+		return rid, nil, nil, err
+	}
+	if !done {
+		return suspend(errors.New("deadline exceeded before every node was initialized and unsealed"))
+	}
+
+	if res.StorageType == storageTypeRaft {
+		if err = res.doRaftPeers(instanceAddr, vaultAddr, cp); err != nil {
+			// joined nodes take a moment to show up in the configuration
+			return suspend(err)
+		}
+	}
+
+	if cp.RootAddress != "" {
+		if err = res.doRevokeRoot(cp.RootAddress); err != nil {
+			return rid, nil, nil, fmt.Errorf("unable to revoke root token, use Custom::VaultGenerateRoot to recover: %v", err)
+		}
+		cp.RootAddress = ""
+	}
+
+	return rid, res.doAttributes(instanceAddr), nil, nil
 }
 
 // Delete is invoked when the resource is deleted.
@@ -168,62 +366,252 @@ func (h *vaultInitHandler) Delete(*cloudformation.Event, *lambdaruntime.Context)
 	return nil
 }
 
-func (res *vaultInitResource) doHealth(group map[string]string) map[string]error {
-	errors := make(map[string]error, len(group))
-
-	var initialized *bool
+// doHealth probes every node concurrently, each with its own client, returning whether any node responded
+// initialized and the errors by instance address.
+func (res *vaultInitResource) doHealth(group map[string]string) (bool, map[string]error) {
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		errors      = make(map[string]error, len(group))
+		initialized = make(map[string]bool, len(group))
+	)
 
 	for ip, addr := range group {
-		if err := res.client.SetAddress(addr); err != nil {
-			errors[ip] = err
-		} else if health, err := res.client.Sys().Health(); err != nil {
-			log.Printf("Vault Init `%s` - Health: %+v", addr, err)
+		client, err := res.newClientForAddress(addr)
+		if err != nil {
+			mu.Lock()
 			errors[ip] = err
-		} else {
-			log.Printf("Vault Init `%s` - Health: %+v", addr, health)
-			if initialized == nil {
-				initialized = &health.Initialized
-			} else if *initialized != health.Initialized {
-				errors[ip] = errSupposedlyUnpossibleInitilizationStateMismatch
+			mu.Unlock()
+			continue
+		}
+		client.SetMaxRetries(0)
+		client.SetClientTimeout(initHealthTimeout)
+
+		wg.Add(1)
+		go func(ip, addr string, client *vaultapi.Client) {
+			defer wg.Done()
+			health, err := client.Sys().Health()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Vault Init `%s` - Health: %+v", addr, err)
+				errors[ip] = err
+			} else {
+				log.Printf("Vault Init `%s` - Health: %+v", addr, health)
+				initialized[ip] = health.Initialized
+			}
+		}(ip, addr, client)
+	}
+	wg.Wait()
+
+	// a single initialized node means the group is initialized, so the others disagree with it, unless they are
+	// yet to join it
+	groupInitialized := false
+	for _, i := range initialized {
+		groupInitialized = groupInitialized || i
+	}
+	for ip, i := range initialized {
+		if groupInitialized && !i && res.StorageType != storageTypeRaft {
+			errors[ip] = errSupposedlyUnpossibleInitilizationStateMismatch
+		}
+	}
+
+	return groupInitialized, errors
+}
+
+// doAttributes gathers the resource's attributes from every instance's health and the leader, logging failures.
+func (res *vaultInitResource) doAttributes(instances []string) *vaultInitAttributes {
+	attrs := &vaultInitAttributes{
+		RootTokenParameterName:     res.RootTokenParameterName,
+		SecretShareParameterName:   res.SecretShareParameterName,
+		RecoveryShareParameterName: res.RecoveryShareParameterName,
+	}
+
+	states := make([]*nodeState, len(instances))
+	healths := make([]*vaultapi.HealthResponse, len(instances))
+	for i, iip := range instances {
+		states[i] = &nodeState{
+			Address: fmt.Sprintf("%s://%s:%s", res.ServerScheme, iip, res.ServerPort),
+		}
+	}
+	index := make(map[*nodeState]int, len(states))
+	for i, state := range states {
+		index[state] = i
+	}
+	res.doSweep(states, func(state *nodeState, client *vaultapi.Client) {
+		health, err := client.Sys().Health()
+		if err != nil {
+			state.Error = err.Error()
+			return
+		}
+		healths[index[state]] = health
+	})
+
+	initialized, unsealed := 0, 0
+	for i, health := range healths {
+		if health == nil {
+			log.Printf("Vault Init `%s` - Attributes: %s", states[i].Address, states[i].Error)
+			continue
+		}
+		if health.Initialized {
+			initialized++
+		}
+		if health.Initialized && !health.Sealed {
+			unsealed++
+			if attrs.Version == "" {
+				attrs.Version = health.Version
+				attrs.ClusterName = health.ClusterName
+				attrs.ClusterID = health.ClusterID
+			}
+			if attrs.LeaderAddress == "" {
+				if client, err := res.newClientForAddress(states[i].Address); err != nil {
+					log.Printf("Vault Init `%s` - Attributes: %s", states[i].Address, err)
+				} else if leader, err := client.Sys().Leader(); err != nil {
+					log.Printf("Vault Init `%s` - Leader: %s", states[i].Address, err)
+				} else {
+					attrs.LeaderAddress = leader.LeaderAddress
+				}
+			}
+		}
+		if res.SealType == "" && health.Initialized {
+			if client, err := res.newClientForAddress(states[i].Address); err == nil {
+				if err = res.detectSealType(client); err != nil {
+					log.Printf("Vault Init `%s` - Seal Status: %s", states[i].Address, err)
+				}
 			}
 		}
 	}
+	attrs.SealType = res.SealType
+	attrs.InitializedNodeCount = fmt.Sprint(initialized)
+	attrs.UnsealedNodeCount = fmt.Sprint(unsealed)
+
+	return attrs
+}
 
-	return errors
+// healthSummary describes why each node failed its health check.
+func healthSummary(group string, addrs map[string]string, errs map[string]error) error {
+	nodes := make([]string, 0, len(errs))
+	for ip, err := range errs {
+		nodes = append(nodes, fmt.Sprintf("%s: %v", addrs[ip], err))
+	}
+	sort.Strings(nodes)
+	return fmt.Errorf("no vault in group `%s` became healthy before the deadline (%s)", group, strings.Join(nodes, "; "))
 }
 
-func (res *vaultInitResource) doInitialize(group map[string]string) error {
+func (res *vaultInitResource) doInitialize(group map[string]string, cp *initCheckpoint, until time.Time) (bool, error) {
 	var secretShares []string
 
+	addrs := make([]string, 0, len(group))
 	for _, addr := range group {
-		if err := res.client.SetAddress(addr); err != nil {
-			return err
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	raftUninitialized := false
+	if res.StorageType == storageTypeRaft {
+		addrs, raftUninitialized = res.raftOrder(addrs)
+	}
+
+	for _, addr := range addrs {
+		state, ok := cp.Nodes[addr]
+		if !ok {
+			state = &nodeState{Address: addr}
+			cp.Nodes[addr] = state
+		}
+		// already seen initialized and unsealed by an earlier invocation
+		if state.Initialized && !state.Sealed {
+			continue
+		}
+
+		if time.Now().Add(initNodeBudget).After(until) {
+			log.Printf("Vault Init `%s` - deferring to next invocation", addr)
+			return false, nil
+		}
+
+		client, err := res.newClientForAddress(addr)
+		if err != nil {
+			return false, err
 		}
+		client.SetMaxRetries(0)
+		client.SetClientTimeout(initHealthTimeout)
 
-		health, err := res.client.Sys().Health()
+		health, err := client.Sys().Health()
 		if err != nil {
 			log.Printf("Vault Init `%s` - Health: %+v", addr, err)
-			return err
+			return false, err
 		}
 		log.Printf("Vault Init `%s` - Health: %+v", addr, health)
-
-		if !health.Initialized && len(secretShares) > 0 {
-			return errSupposedlyUnpossibleInitilizationStateMismatch
+		state.Initialized = health.Initialized
+		state.Sealed = health.Sealed
+		state.Standby = health.Standby
+
+		joined := false
+		if !health.Initialized && res.StorageType == storageTypeRaft && (cp.LeaderAddress != "" || res.raftInitialized(cp)) {
+			if cp.LeaderAddress == "" {
+				if err = res.detectSealType(client); err != nil {
+					return false, err
+				}
+				if res.SealType == sealTypeShamir && (res.ShouldUnseal != "true" || len(res.PGPKeys) > 0) {
+					// only an unsealed node can be joined, and nothing here unseals the initialized ones
+					log.Printf("Vault Init `%s` - Raft Join: skipping, the initialized nodes are sealed, update again once they are unsealed", addr)
+					continue
+				}
+				return false, fmt.Errorf("no unsealed raft node for `%s` to join", addr)
+			}
+			if err = res.doRaftJoin(client, addr, cp.LeaderAddress); err != nil {
+				log.Printf("Vault Init `%s` - Raft Join: %s", addr, err)
+				return false, err
+			}
+			joined = true
+			state.Initialized = true
+		} else if !health.Initialized && cp.Initialized {
+			return false, errSupposedlyUnpossibleInitilizationStateMismatch
 		}
 
-		if !health.Initialized {
+		if !health.Initialized && !joined {
+			if res.StorageType == storageTypeRaft && !raftUninitialized {
+				return false, fmt.Errorf("refusing to initialize raft node `%s` without every node in group `%s` responding uninitialized", addr, res.ServerGroup)
+			}
+			if err = res.detectSealType(client); err != nil {
+				log.Printf("Vault Init `%s` - Seal Status: %s", addr, err)
+				return false, err
+			}
+
 			vii := vaultapi.InitRequest{}
-			vii.SecretShares, _ = strconv.Atoi(res.SecretShares)
-			vii.SecretThreshold, _ = strconv.Atoi(res.SecretThreshold)
+			if res.SealType == sealTypeShamir {
+				vii.SecretShares, _ = strconv.Atoi(res.SecretShares)
+				vii.SecretThreshold, _ = strconv.Atoi(res.SecretThreshold)
+				if vii.PGPKeys, err = resolvePGPKeys(res.PGPKeys); err != nil {
+					return false, err
+				}
+			} else {
+				// auto-unseal stores the master key with the seal, recovery keys take the place of the shards
+				vii.SecretShares = 1
+				vii.SecretThreshold = 1
+				vii.StoredShares = 1
+				vii.RecoveryShares, _ = strconv.Atoi(res.RecoveryShares)
+				vii.RecoveryThreshold, _ = strconv.Atoi(res.RecoveryThreshold)
+				if vii.RecoveryPGPKeys, err = resolvePGPKeys(res.RecoveryPGPKeys); err != nil {
+					return false, err
+				}
+			}
+			if res.RootTokenPGPKey != "" {
+				if vii.RootTokenPGPKey, err = resolvePGPKey(res.RootTokenPGPKey); err != nil {
+					return false, err
+				}
+			}
 
-			log.Printf("Vault Init `%s`: %+v", addr, vii)
-			vio, err := res.client.Sys().Init(&vii)
+			log.Printf("Vault Init `%s`: SealType:%s, SecretShares:%d, SecretThreshold:%d, PGPKeys:%d, RecoveryShares:%d, RecoveryThreshold:%d, RecoveryPGPKeys:%d, RootTokenPGPKey:%t",
+				addr, res.SealType, vii.SecretShares, vii.SecretThreshold, len(vii.PGPKeys), vii.RecoveryShares, vii.RecoveryThreshold, len(vii.RecoveryPGPKeys), vii.RootTokenPGPKey != "")
+			vio, err := client.Sys().Init(&vii)
 			// DO NOT LOG THE RESPONSE
 			if err != nil {
 				log.Printf("Vault Init `%s`: %s", addr, err)
-				return err
+				return false, err
 			}
+			cp.Initialized = true
+			state.Initialized = true
 
+			// the root token is stored even when it is to be revoked, so that revocation survives a continuation
 			rtopts := &parameterOptions{
 				Description:   initDefaultRootTokenDescription,
 				EncryptionKey: res.RootTokenEncryptionKey,
@@ -231,39 +619,233 @@ func (res *vaultInitResource) doInitialize(group map[string]string) error {
 			}
 			if _, err = putParameter(rtopts, res.RootTokenParameterName, vio.RootToken); err != nil {
 				log.Printf("Vault Init `%s` - Parameter: %s", addr, err)
-				return err
+				return false, err
+			}
+			if res.RevokeRootAfterBootstrap == "true" {
+				cp.RootAddress = addr
 			}
 
-			secretShares = vio.Keys
-
-			for i, shard := range secretShares {
+			if res.SealType != sealTypeShamir {
+				recoveryShares := vio.RecoveryKeys
+				if len(vii.RecoveryPGPKeys) > 0 {
+					recoveryShares = vio.RecoveryKeysB64
+				}
 				ssopts := &parameterOptions{
-					Description:   initDefaultSecretShareDescription,
-					EncryptionKey: res.SecretShareEncryptionKey,
+					Description:   initDefaultRecoveryShareDescription,
+					EncryptionKey: res.RecoveryShareEncryptionKey,
 					Overwrite:     false,
 				}
-				ssparm := fmt.Sprintf("%s/%d", res.SecretShareParameterName, i+1)
-				if _, err = putParameter(ssopts, ssparm, shard); err != nil {
+				if err = putShareParameters(ssopts, res.RecoveryShareParameterName, recoveryShares, len(vii.RecoveryPGPKeys) > 0); err != nil {
 					log.Printf("Vault Init `%s` - Parameter: %s", addr, err)
-					return err
+					return false, err
 				}
+				// nothing to unseal with, the seal takes care of it
+				state.Sealed = false
+				if res.StorageType == storageTypeRaft {
+					cp.LeaderAddress = addr
+				}
+				continue
+			}
+
+			secretShares = vio.Keys
+			if len(vii.PGPKeys) > 0 {
+				// encrypted shards are stored as base64 for `base64 -d | gpg -d` by their key-holders
+				secretShares = vio.KeysB64
+			}
+
+			ssopts := &parameterOptions{
+				Description:   initDefaultSecretShareDescription,
+				EncryptionKey: res.SecretShareEncryptionKey,
+				Overwrite:     false,
 			}
+			if err = putShareParameters(ssopts, res.SecretShareParameterName, secretShares, len(vii.PGPKeys) > 0); err != nil {
+				log.Printf("Vault Init `%s` - Parameter: %s", addr, err)
+				return false, err
+			}
+		} else if status, err := client.Sys().SealStatus(); err == nil && !status.RecoverySeal &&
+			(fmt.Sprint(status.N) != res.SecretShares || fmt.Sprint(status.T) != res.SecretThreshold) {
+			log.Printf("Vault Init `%s` - SecretShares:%d, SecretThreshold:%d differ from resource, use Custom::VaultRekey to change them", addr, status.N, status.T)
 		}
 
-		if res.ShouldUnseal == "true" && len(secretShares) > 0 {
-			for _, shard := range secretShares {
-				status, err := res.client.Sys().Unseal(shard)
-				if err != nil {
-					log.Printf("Vault Init `%s` - Unseal: %s", addr, err)
-				} else {
-					log.Printf("Vault Init `%s` - Unseal: %+v", addr, status)
-					if !status.Sealed {
-						break
-					}
-				}
+		// an earlier invocation initialized the group, or the node joined it, so the shards come back from their parameters
+		if (cp.Initialized || joined) && res.SealType == "" {
+			if err = res.detectSealType(client); err != nil {
+				log.Printf("Vault Init `%s` - Seal Status: %s", addr, err)
+				return false, err
+			}
+		}
+		if (cp.Initialized || joined) && len(secretShares) == 0 && res.SealType == sealTypeShamir && res.ShouldUnseal == "true" && len(res.PGPKeys) == 0 {
+			if secretShares, err = readShareParameters(res.SecretShareParameterName); err != nil {
+				return false, err
+			}
+		}
+
+		if res.ShouldUnseal == "true" && len(res.PGPKeys) > 0 {
+			log.Printf("Vault Init `%s` - Unseal: skipping, secret shares are PGP encrypted", addr)
+		} else if res.ShouldUnseal == "true" && len(secretShares) > 0 && health.Sealed {
+			if status, err := res.doUnseal(client, addr, secretShares); err != nil {
+				log.Printf("Vault Init `%s` - Unseal: %s", addr, err)
+				state.Error = err.Error()
+			} else {
+				state.Sealed = status.Sealed
+				state.Unsealed = true
+			}
+		}
+
+		if res.StorageType == storageTypeRaft && cp.LeaderAddress == "" && state.Initialized && !state.Sealed {
+			cp.LeaderAddress = addr
+		}
+	}
+
+	return true, nil
+}
+
+// doRevokeRoot revokes the root token stored by doInitialize at the node that issued it and removes its parameter.
+func (res *vaultInitResource) doRevokeRoot(addr string) error {
+	token, _, err := getParameter(res.RootTokenParameterName)
+	if err != nil {
+		return err
+	}
+
+	client, err := res.newClientForAddress(addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Vault Init `%s` - attempting to revoke root token", addr)
+	client.SetToken(token)
+	if err = client.Auth().Token().RevokeSelf(""); err != nil {
+		return err
+	}
+
+	return deleteParameter(res.RootTokenParameterName)
+}
+
+// doUnseal submits shards to the client's address until it reports being unsealed, returning the last status.
+func (res *vaultInitResource) doUnseal(client *vaultapi.Client, addr string, shares []string) (*vaultapi.SealStatusResponse, error) {
+	var (
+		status *vaultapi.SealStatusResponse
+		err    error
+	)
+	for _, shard := range shares {
+		status, err = client.Sys().Unseal(shard)
+		if err != nil {
+			log.Printf("Vault Init `%s` - Unseal: %s", addr, err)
+		} else {
+			log.Printf("Vault Init `%s` - Unseal: %+v", addr, status)
+			if !status.Sealed {
+				return status, nil
 			}
 		}
 	}
+	if err == nil {
+		err = fmt.Errorf("`%s` remains sealed", addr)
+	}
+	return status, err
+}
+
+// readShareParameters returns the shares stored by putShareParameters, in order.
+func readShareParameters(name string) ([]string, error) {
+	parameters, err := listParametersByPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if parameters[name+shareParameterPGPSuffix] == "true" {
+		return nil, fmt.Errorf("shares under `%s` are PGP encrypted, only their key-holders can use them", name)
+	}
+
+	shares := []string{}
+	for i := 1; ; i++ {
+		shard, ok := parameters[fmt.Sprintf("%s/%d", name, i)]
+		if !ok {
+			break
+		}
+		shares = append(shares, shard)
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares found under `%s`", name)
+	}
+
+	return shares, nil
+}
+
+// detectSealType resolves an unspecified `SealType` from the seal status of the client's node.
+func (res *vaultInitResource) detectSealType(client *vaultapi.Client) error {
+	if res.SealType != "" {
+		return nil
+	}
+
+	status, err := client.Sys().SealStatus()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.Type != "":
+		res.SealType = status.Type
+	case status.RecoverySeal:
+		res.SealType = sealTypeAuto
+	default:
+		res.SealType = sealTypeShamir
+	}
+	log.Printf("Vault Init - Seal Type: %s", res.SealType)
+
+	// the same check as for an explicit `SealType`, which could not be made before the server was asked
+	if res.SealType != sealTypeShamir && len(res.PGPKeys) > 0 {
+		return fmt.Errorf("`PGPKeys` are not applicable to `SealType` %s, use `RecoveryPGPKeys`", res.SealType)
+	}
 
 	return nil
 }
+
+// putShareParameters stores each share under `name/N`, numbered from 1, marking whether they are PGP encrypted.
+func putShareParameters(options *parameterOptions, name string, shares []string, encrypted bool) error {
+	for i, shard := range shares {
+		if _, err := putParameter(options, fmt.Sprintf("%s/%d", name, i+1), shard); err != nil {
+			return err
+		}
+	}
+	_, err := putParameter(options, name+shareParameterPGPSuffix, fmt.Sprint(encrypted))
+	return err
+}
+
+func resolvePGPKeys(refs []string) ([]string, error) {
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		key, err := resolvePGPKey(ref)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// resolvePGPKey returns the base64 encoded public key, reading it from SSM or S3 when given a reference.
+func resolvePGPKey(ref string) (string, error) {
+	return resolveReference("PGP key", ref)
+}
+
+// resolveReference returns the value itself, or reads it from SSM or S3 when given a `ssm:` or `s3://` reference.
+func resolveReference(kind, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "ssm:"):
+		value, _, err := getParameter(strings.TrimPrefix(ref, "ssm:"))
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s `%s`: %v", kind, ref, err)
+		}
+		return strings.TrimSpace(value), nil
+	case strings.HasPrefix(ref, "s3://"):
+		loc := strings.SplitN(strings.TrimPrefix(ref, "s3://"), "/", 2)
+		if len(loc) != 2 {
+			return "", fmt.Errorf("invalid %s reference `%s`", kind, ref)
+		}
+		value, err := getObject(loc[0], loc[1])
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s `%s`: %v", kind, ref, err)
+		}
+		return strings.TrimSpace(string(value)), nil
+	}
+	return ref, nil
+}
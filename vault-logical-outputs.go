@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+const (
+	sensitiveOutputDescription = "Vault Logical Response"
+)
+
+var outputIndexPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// doOutputs stores `SensitiveOutputs` selected from the write's response in SSM, and returns the attributes: the
+// resource's properties plus the selected `Outputs`.
+func (res *vaultLogicalResource) doOutputs(sec *vaultapi.Secret) (interface{}, error) {
+	if len(res.Outputs) == 0 && len(res.SensitiveOutputs) == 0 {
+		return res, nil
+	}
+
+	response := map[string]interface{}{}
+	if sec != nil {
+		b, err := json.Marshal(sec)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, &response); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(res.SensitiveOutputs))
+	for name := range res.SensitiveOutputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// encrypted even without a key of their own, with the account's default key
+	opts := &parameterOptions{
+		Type:          ssm.ParameterTypeSecureString,
+		Description:   sensitiveOutputDescription,
+		EncryptionKey: res.SensitiveOutputEncryptionKey,
+		Overwrite:     true,
+	}
+	for _, name := range names {
+		value, err := selectOutput(response, res.SensitiveOutputs[name])
+		if err != nil {
+			return nil, err
+		}
+		// DO NOT LOG THE VALUE
+		log.Printf("Vault Logical `%s`: storing `%s` in parameter `%s`", res.Path, res.SensitiveOutputs[name], name)
+		if _, err = putParameter(opts, name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	attrs := map[string]interface{}{}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &attrs); err != nil {
+		return nil, err
+	}
+
+	for name, path := range res.Outputs {
+		if _, ok := attrs[name]; ok {
+			return nil, fmt.Errorf("`Outputs` name `%s` collides with a property", name)
+		}
+		if attrs[name], err = selectOutput(response, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return attrs, nil
+}
+
+// selectOutput returns the value at a path such as `data.keys.1` or `$.data.ca_chain[0]` in the response, as a
+// string or otherwise as JSON.
+func selectOutput(response map[string]interface{}, path string) (string, error) {
+	expr := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	expr = outputIndexPattern.ReplaceAllString(expr, ".$1")
+
+	var value interface{} = response
+	for _, segment := range strings.Split(expr, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			field, ok := v[segment]
+			if !ok {
+				return "", fmt.Errorf("no `%s` in response for `%s`", segment, path)
+			}
+			value = field
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("no index `%s` in response for `%s`", segment, path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("no `%s` in response for `%s`", segment, path)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
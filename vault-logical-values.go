@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	generateDefaultLength  = 32
+	generateDefaultCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	generatedParameterInfix       = "Vault/Logical"
+	generatedParameterDescription = "Vault Logical Generated Value"
+)
+
+// resolveData returns `Data` with reference values replaced by what they refer to, keeping the secret material out
+// of the template:
+//
+//	{"FromParameter": "/x/y"}
+//	{"FromSecretsManager": "arn...", "Key": "password"}
+//	{"Generate": {"Length": 32, "Charset": "...", "Rotation": "1"}}
+//
+// Generated values are kept from the current secret unless their reference changed since the previous properties,
+// such as by bumping `Rotation`. They are also stored as SecureString parameters under the resource's own path, which
+// keeps them for paths that cannot be read back.
+func (res *vaultLogicalResource) resolveData(old map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(res.Data))
+
+	var current map[string]interface{}
+	for key, value := range res.Data {
+		ref, ok := value.(map[string]interface{})
+		if !ok {
+			resolved[key] = value
+			continue
+		}
+
+		switch {
+		case ref["FromParameter"] != nil:
+			name := fmt.Sprint(ref["FromParameter"])
+			v, _, err := getParameter(name)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve `%s` from parameter `%s`: %v", key, name, err)
+			}
+			resolved[key] = v
+
+		case ref["FromSecretsManager"] != nil:
+			id := fmt.Sprint(ref["FromSecretsManager"])
+			v, err := getSecretValue(id)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve `%s` from secret `%s`: %v", key, id, err)
+			}
+			if k, ok := ref["Key"]; ok {
+				fields := map[string]interface{}{}
+				if err = json.Unmarshal([]byte(v), &fields); err != nil {
+					return nil, fmt.Errorf("unable to resolve `%s` from secret `%s`: %v", key, id, err)
+				}
+				if resolved[key], ok = fields[fmt.Sprint(k)]; !ok {
+					return nil, fmt.Errorf("unable to resolve `%s` from secret `%s`: no key `%v`", key, id, k)
+				}
+			} else {
+				resolved[key] = v
+			}
+
+		case ref["Generate"] != nil:
+			name := res.generatedPath + "/" + key
+			if reflect.DeepEqual(ref, old[key]) {
+				if current == nil {
+					current = res.readCurrent()
+				}
+				if v, ok := current[key]; ok {
+					resolved[key] = v
+					continue
+				}
+				if v, _, err := getParameter(name); err == nil {
+					resolved[key] = v
+					continue
+				}
+			}
+			spec, _ := ref["Generate"].(map[string]interface{})
+			v, err := generateValue(spec)
+			if err != nil {
+				return nil, fmt.Errorf("unable to generate `%s`: %v", key, err)
+			}
+			log.Printf("Vault Logical `%s`: generated `%s`", res.Path, key)
+
+			opts := &parameterOptions{
+				Type:          ssm.ParameterTypeSecureString,
+				Description:   generatedParameterDescription,
+				EncryptionKey: res.SensitiveOutputEncryptionKey,
+				Overwrite:     true,
+			}
+			// DO NOT LOG THE VALUE
+			if _, err = putParameter(opts, name, v); err != nil {
+				return nil, fmt.Errorf("unable to store generated `%s` in parameter `%s`: %v", key, name, err)
+			}
+			resolved[key] = v
+
+		default:
+			resolved[key] = value
+		}
+	}
+
+	return resolved, nil
+}
+
+// doDeleteGenerated removes the parameters holding generated values, logging failures.
+func (res *vaultLogicalResource) doDeleteGenerated() {
+	generated := false
+	for _, value := range res.Data {
+		if ref, ok := value.(map[string]interface{}); ok && ref["Generate"] != nil {
+			generated = true
+		}
+	}
+	if !generated {
+		return
+	}
+
+	parameters, err := listParametersByPath(res.generatedPath)
+	if err != nil {
+		log.Printf("Vault Logical `%s`: unable to list generated parameters: %v", res.Path, err)
+	}
+	for name := range parameters {
+		log.Printf("Vault Logical `%s`: attempting to delete parameter `%s`", res.Path, name)
+		if err = deleteParameter(name); err != nil {
+			log.Printf("Vault Logical `%s`: unable to delete parameter `%s`: %v", res.Path, name, err)
+		}
+	}
+}
+
+// readCurrent returns the secret currently at `Path`, or nothing when it cannot be read.
+func (res *vaultLogicalResource) readCurrent() map[string]interface{} {
+	sec, err := res.readSecret()
+	if err != nil {
+		log.Printf("Vault Logical `%s`: unable to read current value: %v", res.Path, err)
+	}
+	if sec == nil {
+		return map[string]interface{}{}
+	}
+
+	if res.KVVersion == "2" {
+		data, _ := sec.Data["data"].(map[string]interface{})
+		if data == nil {
+			return map[string]interface{}{}
+		}
+		return data
+	}
+
+	return sec.Data
+}
+
+// generateValue returns a random string of `Length` characters drawn from `Charset`.
+func generateValue(spec map[string]interface{}) (string, error) {
+	length := generateDefaultLength
+	if l, ok := spec["Length"]; ok {
+		// numbers arrive from CloudFormation as strings
+		n, err := strconv.Atoi(fmt.Sprint(l))
+		if err != nil {
+			return "", fmt.Errorf("invalid `Length`: %v", err)
+		}
+		length = n
+	}
+	if length <= 0 {
+		return "", errors.New("`Length` must be positive")
+	}
+
+	charset := []rune(generateDefaultCharset)
+	if c, ok := spec["Charset"]; ok {
+		charset = []rune(fmt.Sprint(c))
+	}
+	if len(charset) == 0 {
+		return "", errors.New("`Charset` must not be empty")
+	}
+
+	max := big.NewInt(int64(len(charset)))
+	value := make([]rune, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		value[i] = charset[n.Int64()]
+	}
+
+	return string(value), nil
+}
@@ -3,12 +3,16 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
 	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
 	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+	vaultapi "github.com/hashicorp/vault/api"
 )
 
 func init() {
@@ -24,6 +28,29 @@ type vaultLogicalResource struct {
 
 	Path string                 `json:",omitempty"`
 	Data map[string]interface{} `json:",omitempty"`
+
+	// `1` or `2`, detected from the mount when not specified
+	KVVersion       string `json:",omitempty"`
+	CAS             string `json:",omitempty"`
+	DestroyOnDelete string `json:",omitempty"`
+
+	// attribute or parameter names to paths in the write's response, such as `data.certificate`
+	Outputs                      map[string]string `json:",omitempty"`
+	SensitiveOutputs             map[string]string `json:",omitempty"`
+	SensitiveOutputEncryptionKey string            `json:",omitempty"`
+
+	// write only when nothing is at `Path` yet
+	CreateOnly string `json:",omitempty"`
+	// on delete, `DeleteData` is written to `DeletePath` before deleting `Path`, or without it `DeletePath` is
+	// deleted instead of `Path`
+	DeletePath string                 `json:",omitempty"`
+	DeleteData map[string]interface{} `json:",omitempty"`
+
+	Version string `json:",omitempty"`
+
+	mount string
+	// parameters keeping generated values are stored under this path
+	generatedPath string
 }
 
 func (h *vaultLogicalHandler) resource(evt *cloudformation.Event) (string, *vaultLogicalResource, error) {
@@ -37,8 +64,41 @@ func (h *vaultLogicalHandler) resource(evt *cloudformation.Event) (string, *vaul
 	if res.Path == "" {
 		return rid, nil, errors.New("missing required resource property `Path`")
 	}
+	res.generatedPath = fmt.Sprintf("/%s/%s/%s", strings.Split(evt.StackID, "/")[1], generatedParameterInfix, rid)
+
+	if res.KVVersion != "" && res.KVVersion != "1" && res.KVVersion != "2" {
+		return rid, nil, fmt.Errorf("unsupported `KVVersion` %s", res.KVVersion)
+	}
+
+	if res.CAS != "" {
+		if _, err := strconv.ParseUint(res.CAS, 10, 64); err != nil {
+			return rid, nil, fmt.Errorf("invalid `CAS`: %v", err)
+		}
+	}
+
+	createOnly, err := strconv.ParseBool(res.CreateOnly)
+	if err != nil {
+		log.Printf("failed to parse `CreateOnly`: %v", err)
+	}
+	res.CreateOnly = fmt.Sprint(createOnly)
 
-	return rid, res, res.initWithTokenParameterOverride()
+	if len(res.DeleteData) > 0 && res.DeletePath == "" {
+		return rid, nil, errors.New("`DeleteData` requires `DeletePath`")
+	}
+
+	destroy, err := strconv.ParseBool(res.DestroyOnDelete)
+	if err != nil {
+		log.Printf("failed to parse `DestroyOnDelete`: %v", err)
+	}
+	res.DestroyOnDelete = fmt.Sprint(destroy)
+
+	if err := res.initWithLogin(evt.ResourceProperties); err != nil {
+		return rid, nil, err
+	}
+
+	res.detectKVVersion()
+
+	return rid, res, nil
 }
 
 // Create is invoked when the resource is created.
@@ -53,11 +113,65 @@ func (h *vaultLogicalHandler) Update(evt *cloudformation.Event, ctx *lambdarunti
 		return rid, nil, err
 	}
 
-	log.Printf("Vault Logical `%s`: attempting write", res.Path)
+	old := &vaultLogicalResource{}
+	if evt.RequestType == "Update" && len(evt.OldResourceProperties) > 0 {
+		if err = json.Unmarshal(evt.OldResourceProperties, old); err != nil {
+			return rid, nil, err
+		}
+	}
+
+	if res.CreateOnly == "true" {
+		sec, err := res.readSecret()
+		if err != nil {
+			return rid, nil, err
+		}
+		if sec != nil {
+			log.Printf("Vault Logical `%s`: skipping write, path exists", res.Path)
+			attrs, err := res.doOutputs(sec)
+			return rid, attrs, err
+		}
+	}
+
+	// resolved values stay out of the resource's attributes
+	resolved, err := res.resolveData(old.Data)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	if res.KVVersion != "2" {
+		log.Printf("Vault Logical `%s`: attempting write", res.Path)
+
+		sec, err := res.client.Logical().Write(res.Path, resolved)
+		if err != nil {
+			return rid, nil, err
+		}
+
+		attrs, err := res.doOutputs(sec)
+		return rid, attrs, err
+	}
+
+	data := map[string]interface{}{
+		"data": resolved,
+	}
+	if res.CAS != "" {
+		cas, _ := strconv.ParseUint(res.CAS, 10, 64)
+		data["options"] = map[string]interface{}{
+			"cas": cas,
+		}
+	}
+
+	log.Printf("Vault Logical `%s`: attempting write", res.kvPath("data"))
 
-	_, err = res.client.Logical().Write(res.Path, res.Data)
+	sec, err := res.client.Logical().Write(res.kvPath("data"), data)
+	if err != nil {
+		return rid, nil, err
+	}
+	if sec != nil && sec.Data != nil {
+		res.Version = fmt.Sprint(int64Value(sec.Data["version"]))
+	}
 
-	return rid, res, err
+	attrs, err := res.doOutputs(sec)
+	return rid, attrs, err
 }
 
 // Delete is invoked when the resource is deleted.
@@ -67,13 +181,95 @@ func (h *vaultLogicalHandler) Delete(evt *cloudformation.Event, ctx *lambdarunti
 	if err == nil {
 		res.client.SetMaxRetries(1)
 		res.client.SetClientTimeout(30 * time.Second)
-		log.Printf("Vault Logical `%s`: attempting delete", res.Path)
-		_, err = res.client.Logical().Delete(res.Path)
+		writeFirst := res.DeletePath != "" && len(res.DeleteData) > 0
+		if writeFirst {
+			log.Printf("Vault Logical `%s`: attempting write before delete", res.DeletePath)
+			_, err = res.client.Logical().Write(res.DeletePath, res.DeleteData)
+		}
+
+		path := res.Path
+		switch {
+		case res.DeletePath != "" && !writeFirst:
+			path = res.DeletePath
+		case res.KVVersion == "2" && res.DestroyOnDelete == "true":
+			// removes every version along with the metadata
+			path = res.kvPath("metadata")
+		case res.KVVersion == "2":
+			// soft-deletes the latest version
+			path = res.kvPath("data")
+		}
+		if err == nil {
+			log.Printf("Vault Logical `%s`: attempting delete", path)
+			_, err = res.client.Logical().Delete(path)
+		}
 	}
 
 	if err != nil {
-		log.Printf("Vault Logical `%s` - skipping delete: %v", res.Path, err)
+		log.Printf("Vault Logical - skipping delete: %v", err)
+	}
+
+	if res != nil {
+		res.doDeleteGenerated()
 	}
 
 	return nil
 }
+
+// readSecret returns what is currently at `Path`, nil when there is nothing or only deleted versions.
+func (res *vaultLogicalResource) readSecret() (*vaultapi.Secret, error) {
+	path := res.Path
+	if res.KVVersion == "2" {
+		path = res.kvPath("data")
+	}
+
+	sec, err := res.client.Logical().Read(path)
+	if err != nil || sec == nil || sec.Data == nil {
+		return nil, err
+	}
+	if res.KVVersion == "2" && sec.Data["data"] == nil {
+		return nil, nil
+	}
+
+	return sec, nil
+}
+
+// detectKVVersion finds the KV version of `Path` when not specified, and the mount of a KV version 2 `Path`. Paths
+// that cannot be looked up, on servers predating the mounts endpoint or for want of permission, are taken as KV
+// version 1, which is how any path that is not KV is written.
+func (res *vaultLogicalResource) detectKVVersion() {
+	if res.KVVersion == "1" {
+		return
+	}
+
+	// servers without the endpoint answer 404, which the client reads as no secret
+	sec, err := res.client.Logical().Read("sys/internal/ui/mounts/" + res.Path)
+	if err != nil {
+		log.Printf("Vault Logical `%s`: unable to lookup mount: %v", res.Path, err)
+	}
+	if err != nil || sec == nil || sec.Data == nil {
+		if res.KVVersion == "2" {
+			// assume the mount is the first path segment
+			res.mount = strings.SplitN(res.Path, "/", 2)[0] + "/"
+			return
+		}
+		res.KVVersion = "1"
+		return
+	}
+
+	res.mount, _ = sec.Data["path"].(string)
+
+	if res.KVVersion == "" {
+		res.KVVersion = "1"
+		if options, ok := sec.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+			res.KVVersion = "2"
+		}
+		log.Printf("Vault Logical `%s`: KV version %s", res.Path, res.KVVersion)
+	}
+}
+
+// kvPath returns the KV version 2 path under the mount's `data/` or `metadata/` prefix.
+func (res *vaultLogicalResource) kvPath(prefix string) string {
+	rest := strings.TrimPrefix(res.Path, res.mount)
+	rest = strings.TrimPrefix(rest, "data/")
+	return res.mount + prefix + "/" + rest
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+const (
+	loginMethodAppRole = "approle"
+	loginMethodAWS     = "aws"
+	loginMethodToken   = "token"
+
+	loginDefaultAppRolePath = "approle"
+	loginDefaultAWSPath     = "aws"
+
+	loginAWSServerIDHeader = "X-Vault-AWS-IAM-Server-ID"
+
+	// tokens expiring within this window are renewed (or re-acquired) before use
+	loginRenewWindow = 5 * time.Minute
+)
+
+// logins are cached for the lifetime of a warm Lambda container
+var loginCache = struct {
+	sync.Mutex
+	tokens map[vaultLogin]*loginToken
+}{
+	tokens: map[vaultLogin]*loginToken{},
+}
+
+type loginToken struct {
+	token     string
+	renewable bool
+	expires   time.Time // zero when the token does not expire
+}
+
+// vaultLogin describes how a handler authenticates to Vault. Values are read
+// from the environment and then overridden by resource properties of the same
+// name, e.g. `VaultLoginMethod` overrides `$VAULT_LOGIN_METHOD`.
+type vaultLogin struct {
+	VaultLoginMethod   string `json:",omitempty"`
+	VaultLoginPath     string `json:",omitempty"`
+	VaultLoginRole     string `json:",omitempty"`
+	VaultLoginServerID string `json:",omitempty"`
+
+	VaultLoginRoleID            string `json:",omitempty"`
+	VaultLoginSecretIDParameter string `json:",omitempty"`
+}
+
+func newVaultLogin(props json.RawMessage) (*vaultLogin, error) {
+	login := &vaultLogin{
+		VaultLoginMethod:   os.Getenv("VAULT_LOGIN_METHOD"),
+		VaultLoginPath:     os.Getenv("VAULT_LOGIN_PATH"),
+		VaultLoginRole:     os.Getenv("VAULT_LOGIN_ROLE"),
+		VaultLoginServerID: os.Getenv("VAULT_LOGIN_SERVER_ID"),
+
+		VaultLoginRoleID:            os.Getenv("VAULT_LOGIN_ROLE_ID"),
+		VaultLoginSecretIDParameter: os.Getenv("VAULT_LOGIN_SECRET_ID_PARAMETER"),
+	}
+
+	if len(props) > 0 {
+		if err := json.Unmarshal(props, login); err != nil {
+			return nil, err
+		}
+	}
+
+	if login.VaultLoginMethod == "" {
+		login.VaultLoginMethod = loginMethodToken
+	}
+
+	if login.VaultLoginPath == "" {
+		switch login.VaultLoginMethod {
+		case loginMethodAppRole:
+			login.VaultLoginPath = loginDefaultAppRolePath
+		case loginMethodAWS:
+			login.VaultLoginPath = loginDefaultAWSPath
+		}
+	}
+
+	return login, nil
+}
+
+// initWithLogin initializes the client and authenticates it with the configured login method,
+// falling back to `$VAULT_TOKEN_PARAMETER` (or `$VAULT_TOKEN`) when no method has been specified.
+func (res *vaultResource) initWithLogin(props json.RawMessage) error {
+	login, err := newVaultLogin(props)
+	if err != nil {
+		return err
+	}
+
+	var loginWith func(*vaultLogin) (*vaultapi.SecretAuth, error)
+
+	switch login.VaultLoginMethod {
+	case loginMethodToken:
+		return res.initWithTokenParameterOverride()
+	case loginMethodAppRole:
+		loginWith = res.loginWithAppRole
+	case loginMethodAWS:
+		loginWith = res.loginWithAWS
+	default:
+		return fmt.Errorf("unsupported login method `%s`", login.VaultLoginMethod)
+	}
+
+	if err := res.init(); err != nil {
+		return err
+	}
+
+	loginCache.Lock()
+	defer loginCache.Unlock()
+
+	if tok, ok := loginCache.tokens[*login]; ok {
+		res.client.SetToken(tok.token)
+		if tok.expires.IsZero() || time.Until(tok.expires) > loginRenewWindow {
+			return nil
+		}
+		if tok.renewable {
+			log.Printf("Vault Login `%s` - attempting to renew cached token", login.VaultLoginPath)
+			sec, err := res.client.Auth().Token().RenewSelf(0)
+			if err == nil && sec != nil && sec.Auth != nil {
+				loginCache.tokens[*login] = newLoginToken(sec.Auth)
+				return nil
+			}
+			log.Printf("Vault Login `%s` - unable to renew cached token: %v", login.VaultLoginPath, err)
+		}
+		delete(loginCache.tokens, *login)
+		res.client.ClearToken()
+	}
+
+	auth, err := loginWith(login)
+	if err != nil {
+		return err
+	}
+
+	loginCache.tokens[*login] = newLoginToken(auth)
+	res.client.SetToken(auth.ClientToken)
+
+	return nil
+}
+
+func newLoginToken(auth *vaultapi.SecretAuth) *loginToken {
+	tok := &loginToken{
+		token:     auth.ClientToken,
+		renewable: auth.Renewable,
+	}
+	if auth.LeaseDuration > 0 {
+		tok.expires = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+	return tok
+}
+
+func (res *vaultResource) loginWithAppRole(login *vaultLogin) (*vaultapi.SecretAuth, error) {
+	if login.VaultLoginRoleID == "" {
+		return nil, fmt.Errorf("login method `%s` requires a role-id", login.VaultLoginMethod)
+	}
+
+	data := map[string]interface{}{
+		"role_id": login.VaultLoginRoleID,
+	}
+
+	if login.VaultLoginSecretIDParameter != "" {
+		log.Printf("Vault Login `%s` - reading secret-id parameter `%s` ...", login.VaultLoginPath, login.VaultLoginSecretIDParameter)
+		secretID, _, err := getParameter(login.VaultLoginSecretIDParameter)
+		if err != nil {
+			return nil, err
+		}
+		data["secret_id"] = secretID
+	}
+
+	return res.doLogin(login, data)
+}
+
+func (res *vaultResource) loginWithAWS(login *vaultLogin) (*vaultapi.SecretAuth, error) {
+	if login.VaultLoginRole == "" {
+		return nil, fmt.Errorf("login method `%s` requires a role", login.VaultLoginMethod)
+	}
+
+	gcir, _ := securityTokenService.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	if login.VaultLoginServerID != "" {
+		gcir.HTTPRequest.Header.Add(loginAWSServerIDHeader, login.VaultLoginServerID)
+	}
+	if err := gcir.Sign(); err != nil {
+		return nil, err
+	}
+
+	headers, err := json.Marshal(gcir.HTTPRequest.Header)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(gcir.HTTPRequest.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role":                    login.VaultLoginRole,
+		"iam_http_request_method": gcir.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(gcir.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}
+
+	return res.doLogin(login, data)
+}
+
+func (res *vaultResource) doLogin(login *vaultLogin, data map[string]interface{}) (*vaultapi.SecretAuth, error) {
+	path := fmt.Sprintf("auth/%s/login", login.VaultLoginPath)
+	log.Printf("Vault Login `%s` - attempting %s login", path, login.VaultLoginMethod)
+
+	// DO NOT LOG THE RESPONSE
+	sec, err := res.client.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil || sec.Auth == nil {
+		return nil, fmt.Errorf("login `%s` returned no auth", path)
+	}
+
+	return sec.Auth, nil
+}
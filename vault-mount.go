@@ -70,7 +70,7 @@ func (h *vaultMountHandler) resource(evt *cloudformation.Event) (string, *vaultM
 		res.Path += "/"
 	}
 
-	return rid, res, res.initWithTokenParameterOverride()
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
 }
 
 // Create is invoked when the resource is created.
@@ -39,7 +39,7 @@ func (h *vaultPolicyHandler) resource(evt *cloudformation.Event) (string, *vault
 		return rid, nil, errors.New("missing required resource property `Rules`")
 	}
 
-	return rid, res, res.initWithTokenParameterOverride()
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
 }
 
 // Create is invoked when the resource is created.
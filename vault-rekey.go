@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+func init() {
+	customresource.Register("VaultRekey", new(vaultRekeyHandler))
+}
+
+const (
+	// the new shares are written here first, and left behind if they cannot replace the old ones
+	rekeyStagedSuffix = "/Staged"
+)
+
+// vaultRekeyResource rotates the shards stored by Custom::VaultInit, optionally changing their number, threshold
+// or PGP recipients. With `Recovery` it rotates the recovery keys of an auto-unsealed cluster instead. Every create
+// and update rekeys, so change `Serial` to rotate without changing anything else.
+type vaultRekeyHandler struct{}
+type vaultRekeyResource struct {
+	vaultResource `json:"-"`
+
+	SecretShareEncryptionKey string `json:",omitempty"`
+	SecretShareParameterName string `json:",omitempty"`
+
+	SecretShares    string `json:",omitempty"`
+	SecretThreshold string `json:",omitempty"`
+
+	// public keys as base64, `ssm:/parameter/name` or `s3://bucket/key` references
+	PGPKeys []string `json:",omitempty"`
+
+	Recovery string `json:",omitempty"`
+	Serial   string `json:",omitempty"`
+}
+
+func (h *vaultRekeyHandler) resource(evt *cloudformation.Event) (string, *vaultRekeyResource, error) {
+	rid := resourceID(evt)
+	res := &vaultRekeyResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	recovery, err := strconv.ParseBool(res.Recovery)
+	if err != nil {
+		log.Printf("failed to parse `Recovery`: %v", err)
+	}
+	res.Recovery = fmt.Sprint(recovery)
+
+	if res.SecretShareParameterName == "" {
+		suffix := initDefaultSecretShareSuffix
+		if recovery {
+			suffix = initDefaultRecoveryShareSuffix
+		}
+		res.SecretShareParameterName = fmt.Sprintf("/%s/Vault/%s", strings.Split(evt.StackID, "/")[1], suffix)
+	}
+
+	// left unset, the number of shares and threshold stay as they are
+	if secretShares, err := strconv.Atoi(res.SecretShares); err != nil {
+		log.Printf("failed to parse `SecretShares`: %v", err)
+		res.SecretShares = ""
+	} else {
+		res.SecretShares = fmt.Sprint(secretShares)
+	}
+
+	if secretThreshold, err := strconv.Atoi(res.SecretThreshold); err != nil {
+		log.Printf("failed to parse `SecretThreshold`: %v", err)
+		res.SecretThreshold = ""
+	} else {
+		res.SecretThreshold = fmt.Sprint(secretThreshold)
+	}
+
+	if len(res.PGPKeys) > 0 {
+		if res.SecretShares == "" {
+			res.SecretShares = fmt.Sprint(len(res.PGPKeys))
+		}
+		if res.SecretShares != fmt.Sprint(len(res.PGPKeys)) {
+			return rid, nil, fmt.Errorf("number of `PGPKeys` (%d) must equal `SecretShares` (%s)", len(res.PGPKeys), res.SecretShares)
+		}
+	}
+
+	// rekeying is authorized by the shards rather than a token
+	return rid, res, res.init()
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultRekeyHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	return h.Update(evt, ctx)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultRekeyHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.doRekey()
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultRekeyHandler) Delete(*cloudformation.Event, *lambdaruntime.Context) error {
+	return nil
+}
+
+func (res *vaultRekeyResource) doRekey() error {
+	shares, err := readShareParameters(res.SecretShareParameterName)
+	if err != nil {
+		return err
+	}
+
+	req := &vaultapi.RekeyInitRequest{}
+	req.SecretShares, _ = strconv.Atoi(res.SecretShares)
+	req.SecretThreshold, _ = strconv.Atoi(res.SecretThreshold)
+	if req.PGPKeys, err = resolvePGPKeys(res.PGPKeys); err != nil {
+		return err
+	}
+
+	sys := res.client.Sys()
+
+	// the seal status reports the recovery configuration of an auto-unsealed cluster, the barrier's otherwise
+	if req.SecretShares == 0 || req.SecretThreshold == 0 {
+		seal, err := sys.SealStatus()
+		if err != nil {
+			return err
+		}
+		if req.SecretShares == 0 {
+			req.SecretShares = seal.N
+		}
+		if req.SecretThreshold == 0 {
+			req.SecretThreshold = seal.T
+		}
+	}
+	if req.SecretThreshold > req.SecretShares {
+		req.SecretThreshold = req.SecretShares
+	}
+	res.SecretShares, res.SecretThreshold = fmt.Sprint(req.SecretShares), fmt.Sprint(req.SecretThreshold)
+	status, cancel, init, update := sys.RekeyStatus, sys.RekeyCancel, sys.RekeyInit, sys.RekeyUpdate
+	if res.Recovery == "true" {
+		status, cancel, init, update = sys.RekeyRecoveryKeyStatus, sys.RekeyRecoveryKeyCancel, sys.RekeyRecoveryKeyInit, sys.RekeyRecoveryKeyUpdate
+	}
+
+	current, err := status()
+	if err != nil {
+		return err
+	}
+	if current.Started {
+		log.Printf("Vault Rekey `%s` - cancelling rekey in progress", res.SecretShareParameterName)
+		if err = cancel(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Vault Rekey `%s` - attempting rekey: Recovery:%s, SecretShares:%d, SecretThreshold:%d, PGPKeys:%d",
+		res.SecretShareParameterName, res.Recovery, req.SecretShares, req.SecretThreshold, len(req.PGPKeys))
+	started, err := init(req)
+	if err != nil {
+		return err
+	}
+
+	var result *vaultapi.RekeyUpdateResponse
+	for _, shard := range shares {
+		// DO NOT LOG THE RESPONSE
+		if result, err = update(shard, started.Nonce); err != nil || result.Complete {
+			break
+		}
+		log.Printf("Vault Rekey `%s` - submitted shard", res.SecretShareParameterName)
+	}
+	if err == nil && (result == nil || !result.Complete) {
+		err = fmt.Errorf("insufficient shares under `%s`", res.SecretShareParameterName)
+	}
+	if err != nil {
+		if cerr := cancel(); cerr != nil {
+			log.Printf("Vault Rekey `%s` - unable to cancel rekey: %v", res.SecretShareParameterName, cerr)
+		}
+		return err
+	}
+
+	// the old shares are useless from here on, so failing to store the new ones loses the keys
+	newShares := result.Keys
+	if len(req.PGPKeys) > 0 {
+		newShares = result.KeysB64
+	}
+	encrypted := len(req.PGPKeys) > 0
+
+	description := initDefaultSecretShareDescription
+	if res.Recovery == "true" {
+		description = initDefaultRecoveryShareDescription
+	}
+	ssopts := &parameterOptions{
+		Description:   description,
+		EncryptionKey: res.SecretShareEncryptionKey,
+		Overwrite:     true,
+	}
+
+	staged := res.SecretShareParameterName + rekeyStagedSuffix
+	log.Printf("Vault Rekey `%s` - staging new shares", staged)
+	if err = putShareParameters(ssopts, staged, newShares, encrypted); err == nil {
+		err = verifyShareParameters(staged, newShares, encrypted)
+	}
+	if err != nil {
+		log.Printf("Vault Rekey `%s` - Parameter: %s", staged, err)
+		return fmt.Errorf("rekey completed but the new shares could not all be staged: %v", err)
+	}
+
+	if err = putShareParameters(ssopts, res.SecretShareParameterName, newShares, encrypted); err == nil {
+		err = verifyShareParameters(res.SecretShareParameterName, newShares, encrypted)
+	}
+	if err != nil {
+		log.Printf("Vault Rekey `%s` - Parameter: %s", res.SecretShareParameterName, err)
+		return fmt.Errorf("rekey completed but the new shares could not all be stored, they remain under `%s`: %v", staged, err)
+	}
+
+	// remove stale shards from the end so readers never see a gap before the last valid one
+	for i := len(shares); i > len(newShares); i-- {
+		name := fmt.Sprintf("%s/%d", res.SecretShareParameterName, i)
+		log.Printf("Vault Rekey `%s` - deleting stale parameter", name)
+		if err = deleteParameter(name); err != nil {
+			return err
+		}
+	}
+
+	parameters, err := listParametersByPath(staged)
+	if err != nil {
+		log.Printf("Vault Rekey `%s` - unable to list staged parameters: %v", staged, err)
+	}
+	for name := range parameters {
+		if err = deleteParameter(name); err != nil {
+			log.Printf("Vault Rekey `%s` - unable to delete staged parameter: %v", name, err)
+		}
+	}
+
+	log.Printf("Vault Rekey `%s` - complete", res.SecretShareParameterName)
+
+	return nil
+}
+
+// verifyShareParameters checks that the parameters under `name` hold exactly the given shares.
+func verifyShareParameters(name string, shares []string, encrypted bool) error {
+	parameters, err := listParametersByPath(name)
+	if err != nil {
+		return err
+	}
+
+	for i, shard := range shares {
+		if parameters[fmt.Sprintf("%s/%d", name, i+1)] != shard {
+			return fmt.Errorf("share %d under `%s` does not match", i+1, name)
+		}
+	}
+	if parameters[name+shareParameterPGPSuffix] != fmt.Sprint(encrypted) {
+		return fmt.Errorf("shares under `%s` are not marked as they were stored", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+func init() {
+	customresource.Register("VaultGenerateRoot", new(vaultGenerateRootHandler))
+}
+
+const (
+	generateRootDefaultDescription = "Vault Generated Root Token"
+
+	generateRootOTPLength = 16
+)
+
+// vaultGenerateRootResource mints a root token from the shards under `SecretShareParameterName` into
+// `RootTokenParameterName` for the handlers that follow it, or with `Revoke` revokes and removes that token again.
+// The shards default to the unseal keys, an auto-unsealed cluster needs `SecretShareParameterName` set to its
+// recovery shares instead.
+// A stack typically has one of each, the revoking one depending on everything that needs root, and passes both a
+// common `Serial` so that they run again on every stack update.
+type vaultGenerateRootHandler struct{}
+type vaultGenerateRootResource struct {
+	vaultResource `json:"-"`
+
+	RootTokenEncryptionKey   string `json:",omitempty"`
+	RootTokenParameterName   string `json:",omitempty"`
+	SecretShareParameterName string `json:",omitempty"`
+
+	Revoke string `json:",omitempty"`
+	Serial string `json:",omitempty"`
+}
+
+func (h *vaultGenerateRootHandler) resource(evt *cloudformation.Event) (string, *vaultGenerateRootResource, error) {
+	rid := resourceID(evt)
+	res := &vaultGenerateRootResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	stcknm := strings.Split(evt.StackID, "/")[1]
+
+	if res.RootTokenParameterName == "" {
+		res.RootTokenParameterName = fmt.Sprintf("/%s/Vault/%s", stcknm, initDefaultRootTokenSuffix)
+	}
+
+	if res.SecretShareParameterName == "" {
+		res.SecretShareParameterName = fmt.Sprintf("/%s/Vault/%s", stcknm, initDefaultSecretShareSuffix)
+	}
+
+	revoke, err := strconv.ParseBool(res.Revoke)
+	if err != nil {
+		log.Printf("failed to parse `Revoke`: %v", err)
+	}
+	res.Revoke = fmt.Sprint(revoke)
+
+	// generate-root is unauthenticated, revocation authenticates as the token being revoked
+	return rid, res, res.init()
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultGenerateRootHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	return h.Update(evt, ctx)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultGenerateRootHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	if res.Revoke == "true" {
+		return rid, res, res.doRevoke()
+	}
+
+	return rid, res, res.doGenerate()
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultGenerateRootHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+	if err == nil && res.Revoke != "true" {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		err = res.doRevoke()
+	}
+
+	if err != nil {
+		log.Printf("Vault Generate Root - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultGenerateRootResource) doGenerate() error {
+	shares, err := readShareParameters(res.SecretShareParameterName)
+	if err != nil {
+		return err
+	}
+
+	attempt, err := res.generateRootAttempt("GET", nil)
+	if err != nil {
+		return err
+	}
+	if attempt.Started {
+		log.Printf("Vault Generate Root - cancelling attempt in progress")
+		if err = res.client.Sys().GenerateRootCancel(); err != nil {
+			return err
+		}
+	}
+
+	// servers reporting an OTP length generate the one-time-pad themselves
+	legacy := attempt.OTPLength == 0
+	body := map[string]interface{}{}
+	var pad []byte
+	if legacy {
+		pad = make([]byte, generateRootOTPLength)
+		if _, err = rand.Read(pad); err != nil {
+			return err
+		}
+		body["otp"] = base64.StdEncoding.EncodeToString(pad)
+	}
+
+	log.Printf("Vault Generate Root - attempting to generate root token")
+	if attempt, err = res.generateRootAttempt("PUT", body); err != nil {
+		return err
+	}
+	if !legacy {
+		if attempt.OTP == "" {
+			return fmt.Errorf("somehow got no one-time-pad for an OTP length of %d", attempt.OTPLength)
+		}
+		pad = []byte(attempt.OTP)
+	}
+
+	status := &attempt.GenerateRootStatusResponse
+	for _, shard := range shares {
+		if status, err = res.client.Sys().GenerateRootUpdate(shard, status.Nonce); err != nil {
+			break
+		}
+		log.Printf("Vault Generate Root - Progress: %d/%d", status.Progress, status.Required)
+		if status.Complete {
+			break
+		}
+	}
+	if err == nil && !status.Complete {
+		err = fmt.Errorf("insufficient shares under `%s`", res.SecretShareParameterName)
+	}
+	if err != nil {
+		if cerr := res.client.Sys().GenerateRootCancel(); cerr != nil {
+			log.Printf("Vault Generate Root - unable to cancel attempt: %v", cerr)
+		}
+		return err
+	}
+
+	encoded := status.EncodedRootToken
+	if encoded == "" {
+		encoded = status.EncodedToken
+	}
+	// DO NOT LOG THE TOKEN
+	token, err := decodeRootToken(encoded, pad, legacy)
+	if err != nil {
+		return err
+	}
+
+	rtopts := &parameterOptions{
+		Description:   generateRootDefaultDescription,
+		EncryptionKey: res.RootTokenEncryptionKey,
+		Overwrite:     true,
+	}
+	if _, err = putParameter(rtopts, res.RootTokenParameterName, token); err != nil {
+		log.Printf("Vault Generate Root - Parameter: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (res *vaultGenerateRootResource) doRevoke() error {
+	token, _, err := getParameter(res.RootTokenParameterName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Vault Generate Root `%s` - attempting to revoke root token", res.RootTokenParameterName)
+	res.client.SetToken(token)
+	defer res.client.ClearToken()
+	if err = res.client.Auth().Token().RevokeSelf(""); err != nil {
+		return err
+	}
+
+	log.Printf("Vault Generate Root `%s` - attempting to delete parameter", res.RootTokenParameterName)
+	return deleteParameter(res.RootTokenParameterName)
+}
+
+// generateRootAttempt reads or starts a root token generation attempt, including the fields the client omits.
+func (res *vaultGenerateRootResource) generateRootAttempt(method string, body map[string]interface{}) (*generateRootAttempt, error) {
+	r := res.client.NewRequest(method, "/v1/sys/generate-root/attempt")
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := res.client.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	attempt := &generateRootAttempt{}
+	return attempt, resp.DecodeJSON(attempt)
+}
+
+// generateRootAttempt adds the one-time-pad that servers since Vault 1.0 generate and return when an attempt starts.
+type generateRootAttempt struct {
+	vaultapi.GenerateRootStatusResponse
+
+	OTP       string `json:"otp"`
+	OTPLength int    `json:"otp_length"`
+}
+
+// decodeRootToken reverses the one-time-pad applied to a generated root token. Before Vault 1.0 the pad is random
+// bytes of the length of a uuid root token, since then it is the server's `otp`, as long as the token itself.
+func decodeRootToken(encoded string, pad []byte, legacy bool) (string, error) {
+	// newer servers leave out the padding
+	xored, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", err
+	}
+	if len(xored) != len(pad) {
+		return "", fmt.Errorf("encoded root token length %d does not match one-time-pad length %d", len(xored), len(pad))
+	}
+
+	token := make([]byte, len(xored))
+	for i := range xored {
+		token[i] = xored[i] ^ pad[i]
+	}
+
+	if !legacy {
+		return string(token), nil
+	}
+
+	// root tokens are uuids
+	return fmt.Sprintf("%x-%x-%x-%x-%x", token[0:4], token[4:6], token[6:8], token[8:10], token[10:16]), nil
+}
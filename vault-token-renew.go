@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+)
+
+var (
+	// HandleTokenRenewal is the Lambda entrypoint for scheduled renewal of tokens created by `Custom::VaultToken`.
+	// It is meant to be triggered by a CloudWatch Events schedule, optionally with a constant input such as
+	// `{"ParameterPath": "/MyStack/Vault/Token"}` overriding `$VAULT_TOKEN_RENEWAL_PATH`. Only tokens with the default
+	// `AccessorParameterName` are found, other parameters under the path are logged and skipped.
+	HandleTokenRenewal func(json.RawMessage, *lambdaruntime.Context) (interface{}, error)
+)
+
+func init() {
+	HandleTokenRenewal = handleTokenRenewal
+}
+
+const (
+	renewalDefaultWarning = 72 * time.Hour
+)
+
+type tokenRenewalRequest struct {
+	ParameterPath string `json:",omitempty"`
+	Warning       string `json:",omitempty"`
+}
+
+type tokenRenewal struct {
+	ParameterName string `json:",omitempty"`
+	Renewed       bool
+	Wrapped       bool   `json:",omitempty"`
+	NotRenewable  bool   `json:",omitempty"`
+	TTL           int64  `json:",omitempty"`
+	ExpireTime    string `json:",omitempty"`
+	NearMaxTTL    bool   `json:",omitempty"`
+	Error         string `json:",omitempty"`
+}
+
+func handleTokenRenewal(evt json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	req := &tokenRenewalRequest{
+		ParameterPath: os.Getenv("VAULT_TOKEN_RENEWAL_PATH"),
+		Warning:       os.Getenv("VAULT_TOKEN_RENEWAL_WARNING"),
+	}
+	if err := json.Unmarshal(evt, req); err != nil {
+		log.Printf("Vault Token Renewal - ignoring unparseable event: %v", err)
+	}
+
+	if req.ParameterPath == "" {
+		return nil, errors.New("missing required `ParameterPath` (or $VAULT_TOKEN_RENEWAL_PATH)")
+	}
+
+	warning := renewalDefaultWarning
+	if req.Warning != "" {
+		d, err := time.ParseDuration(req.Warning)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `Warning`: %v", err)
+		}
+		warning = d
+	}
+
+	parameters, err := listParametersByPath(req.ParameterPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens created by Custom::VaultToken are persisted with a sibling accessor parameter, any other parameter
+	// (including a token with a custom `AccessorParameterName`) cannot be told apart from a token and is skipped
+	names, skipped := []string{}, []string{}
+	for name := range parameters {
+		if _, ok := parameters[name+tokenAccessorParameterSuffix]; ok {
+			names = append(names, name)
+			continue
+		}
+		if token := strings.TrimSuffix(name, tokenAccessorParameterSuffix); token != name {
+			if _, ok := parameters[token]; ok {
+				continue
+			}
+		}
+		skipped = append(skipped, name)
+	}
+	sort.Strings(names)
+	sort.Strings(skipped)
+
+	for _, name := range skipped {
+		log.Printf("Vault Token Renewal `%s` - skipping `%s`: no sibling `%s` parameter", req.ParameterPath, name, tokenAccessorParameterSuffix)
+	}
+
+	log.Printf("Vault Token Renewal `%s` - found %d token(s)", req.ParameterPath, len(names))
+
+	res := &vaultTokenResource{}
+	if err = res.initWithLogin(nil); err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	renewals := make([]*tokenRenewal, 0, len(names))
+	for _, name := range names {
+		res.ParameterName = name
+		renewal := res.doRenewal(parameters[name], warning)
+		renewals = append(renewals, renewal)
+		if renewal.Error != "" {
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return renewals, fmt.Errorf("failed to renew %d token(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return renewals, nil
+}
+
+func (res *vaultTokenResource) doRenewal(token string, warning time.Duration) *tokenRenewal {
+	renewal := &tokenRenewal{
+		ParameterName: res.ParameterName,
+	}
+
+	// a wrapping token is single use, so it is only ever looked up as one
+	if res.isWrappingToken(token) {
+		log.Printf("Vault Token `%s` - Renew: skipping wrapping token", res.ParameterName)
+		renewal.Wrapped = true
+		return renewal
+	}
+
+	sec, renewable, err := res.doRenew(token)
+	if err != nil {
+		log.Printf("Vault Token `%s` - Renew: %v", res.ParameterName, err)
+		renewal.Error = err.Error()
+		return renewal
+	}
+	if !renewable {
+		log.Printf("Vault Token `%s` - Renew: skipping token that is not renewable", res.ParameterName)
+		renewal.NotRenewable = true
+	}
+	renewal.Renewed = renewable
+
+	if sec != nil && sec.Data != nil {
+		renewal.TTL = int64Value(sec.Data["ttl"])
+		renewal.ExpireTime, _ = sec.Data["expire_time"].(string)
+
+		// a token with an explicit max TTL cannot be renewed past it, so flag those running out of road
+		if explicitMaxTTL := int64Value(sec.Data["explicit_max_ttl"]); explicitMaxTTL > 0 {
+			if created := int64Value(sec.Data["creation_time"]); created > 0 {
+				maxExpires := time.Unix(created, 0).Add(time.Duration(explicitMaxTTL) * time.Second)
+				renewal.NearMaxTTL = time.Until(maxExpires) < warning
+			}
+		}
+	}
+
+	log.Printf("Vault Token `%s` - Renew: TTL:%d, ExpireTime:%s, NearMaxTTL:%t", res.ParameterName, renewal.TTL, renewal.ExpireTime, renewal.NearMaxTTL)
+
+	return renewal
+}
+
+// isWrappingToken reports whether the token is a response-wrapping token, as stored when `WrapTTL` is set.
+func (res *vaultTokenResource) isWrappingToken(token string) bool {
+	sec, err := res.client.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+		"token": token,
+	})
+	return err == nil && sec != nil
+}
+
+// int64Value converts the numeric forms found in decoded Vault responses.
+func int64Value(v interface{}) int64 {
+	switch n := v.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
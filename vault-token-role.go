@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+)
+
+func init() {
+	customresource.Register("VaultTokenRole", new(vaultTokenRoleHandler))
+}
+
+type vaultTokenRoleHandler struct{}
+type vaultTokenRoleResource struct {
+	vaultResource `json:"-"`
+
+	Name               string   `json:",omitempty"`
+	AllowedPolicies    []string `json:",omitempty"`
+	DisallowedPolicies []string `json:",omitempty"`
+	Orphan             string   `json:",omitempty"`
+	Period             string   `json:",omitempty"`
+	Renewable          string   `json:",omitempty"`
+	PathSuffix         string   `json:",omitempty"`
+	BoundCIDRs         []string `json:",omitempty"`
+	ExplicitMaxTTL     string   `json:",omitempty"`
+}
+
+func (h *vaultTokenRoleHandler) resource(evt *cloudformation.Event) (string, *vaultTokenRoleResource, error) {
+	rid := resourceID(evt)
+	res := &vaultTokenRoleResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	if res.Name == "" {
+		return rid, nil, errors.New("missing required resource property `Name`")
+	}
+
+	if strings.Contains(res.PathSuffix, "/") {
+		return rid, nil, errors.New("`PathSuffix` cannot contain `/`")
+	}
+
+	for name, ttl := range map[string]string{
+		"Period":         res.Period,
+		"ExplicitMaxTTL": res.ExplicitMaxTTL,
+	} {
+		if err := validateTTL(name, ttl); err != nil {
+			return rid, nil, err
+		}
+	}
+
+	orphan, err := strconv.ParseBool(res.Orphan)
+	if err != nil {
+		log.Printf("failed to parse `Orphan`: %v", err)
+	}
+	res.Orphan = fmt.Sprint(orphan)
+
+	renewable := true
+	if res.Renewable != "" {
+		if b, err := strconv.ParseBool(res.Renewable); err != nil {
+			log.Printf("failed to parse `Renewable`: %v", err)
+		} else {
+			renewable = b
+		}
+	}
+	res.Renewable = fmt.Sprint(renewable)
+
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultTokenRoleHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	return h.Update(evt, ctx)
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultTokenRoleHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	data := map[string]interface{}{
+		"allowed_policies":    strings.Join(res.AllowedPolicies, ","),
+		"disallowed_policies": strings.Join(res.DisallowedPolicies, ","),
+		"orphan":              res.Orphan == "true",
+		"renewable":           res.Renewable == "true",
+		"path_suffix":         res.PathSuffix,
+		"bound_cidrs":         strings.Join(res.BoundCIDRs, ","),
+		"period":              res.Period,
+		"explicit_max_ttl":    res.ExplicitMaxTTL,
+	}
+
+	if evt.RequestType == "Update" {
+		old := &vaultTokenRoleResource{}
+		if err = json.Unmarshal(evt.OldResourceProperties, old); err != nil {
+			return rid, nil, err
+		}
+		// a different role is a new resource, so CloudFormation deletes the old one
+		if res.Name != old.Name {
+			rid = customresource.NewPhysicalResourceID(evt)
+		}
+	}
+
+	log.Printf("Vault Token Role `%s` - attempting %s", res.rolePath(), strings.ToLower(evt.RequestType))
+
+	_, err = res.client.Logical().Write(res.rolePath(), data)
+
+	return rid, res, err
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultTokenRoleHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+	if err == nil {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		log.Printf("Vault Token Role `%s` - attempting delete", res.rolePath())
+		_, err = res.client.Logical().Delete(res.rolePath())
+	}
+
+	if err != nil {
+		log.Printf("Vault Token Role - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultTokenRoleResource) rolePath() string {
+	return fmt.Sprintf("auth/token/roles/%s", res.Name)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -22,33 +23,54 @@ type vaultTokenHandler struct{}
 type vaultTokenResource struct {
 	vaultResource `json:"-"`
 
-	Role                 string            `json:",omitempty"`
-	ParameterKey         string            `json:",omitempty"`
-	ParameterName        string            `json:",omitempty"`
-	ParameterDescription string            `json:",omitempty"`
-	Policies             []string          `json:",omitempty"`
-	Metadata             map[string]string `json:",omitempty"`
-	ExplicitMaxTTL       string            `json:",omitempty"`
-	TTL                  string            `json:",omitempty"`
-	Period               string            `json:",omitempty"`
-	UseLimit             string            `json:",omitempty"`
-	NoParent             string            `json:",omitempty"`
-	NoDefaultPolicy      string            `json:",omitempty"`
-	Renewable            string            `json:",omitempty"`
-	RevokeOnDelete       string            `json:",omitempty"`
+	Role                  string            `json:",omitempty"`
+	ParameterKey          string            `json:",omitempty"`
+	ParameterName         string            `json:",omitempty"`
+	ParameterDescription  string            `json:",omitempty"`
+	AccessorParameterName string            `json:",omitempty"`
+	Policies              []string          `json:",omitempty"`
+	Metadata              map[string]string `json:",omitempty"`
+	ExplicitMaxTTL        string            `json:",omitempty"`
+	TTL                   string            `json:",omitempty"`
+	Period                string            `json:",omitempty"`
+	UseLimit              string            `json:",omitempty"`
+	NoParent              string            `json:",omitempty"`
+	NoDefaultPolicy       string            `json:",omitempty"`
+	Renewable             string            `json:",omitempty"`
+	RevokeOnDelete        string            `json:",omitempty"`
+	DeleteParameter       string            `json:",omitempty"`
+	WrapTTL               string            `json:",omitempty"`
+	WrappingAccessor      string            `json:",omitempty"`
 }
 
 func (h *vaultTokenHandler) resource(evt *cloudformation.Event) (string, *vaultTokenResource, error) {
 	rid := resourceID(evt)
-	res := &vaultTokenResource{}
 
-	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+	res, err := h.properties(evt.ResourceProperties, evt.StackID, rid)
+	if err != nil {
 		return rid, nil, err
 	}
 
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+func (h *vaultTokenHandler) properties(props json.RawMessage, stackID, rid string) (*vaultTokenResource, error) {
+	res := &vaultTokenResource{}
+
+	if err := json.Unmarshal(props, res); err != nil {
+		return nil, err
+	}
+
 	if res.ParameterName == "" {
-		stcknm := strings.Split(evt.StackID, "/")[1]
-		res.ParameterName = fmt.Sprintf("/%s/%s/%s", stcknm, tokenParameterInfix, evt.PhysicalResourceID)
+		stcknm := strings.Split(stackID, "/")[1]
+		res.ParameterName = fmt.Sprintf("/%s/%s/%s", stcknm, tokenParameterInfix, rid)
+	}
+
+	if res.AccessorParameterName == "" {
+		res.AccessorParameterName = res.ParameterName + tokenAccessorParameterSuffix
+	}
+	if res.AccessorParameterName == res.ParameterName {
+		return nil, errors.New("AccessorParameterName must be different than ParameterName")
 	}
 
 	noParent, err := strconv.ParseBool(res.NoParent)
@@ -76,17 +98,30 @@ func (h *vaultTokenHandler) resource(evt *cloudformation.Event) (string, *vaultT
 	}
 	res.RevokeOnDelete = fmt.Sprint(revokeOnDelete)
 
+	deleteParameter, err := strconv.ParseBool(res.DeleteParameter)
+	if err != nil {
+		log.Printf("failed to parse `DeleteParameter`: %v", err)
+	}
+	res.DeleteParameter = fmt.Sprint(deleteParameter)
+
 	useLimit, err := strconv.Atoi(res.UseLimit)
 	if err != nil {
 		log.Printf("failed to parse `UseLimit`: %v", err)
 	}
 	res.UseLimit = fmt.Sprint(useLimit)
 
-	return rid, res, res.initWithTokenParameterOverride()
+	if err := validateTTL("WrapTTL", res.WrapTTL); err != nil {
+		return nil, err
+	}
+
+	return res, nil
 }
 
 const (
 	tokenParameterInfix = "Vault/Token"
+
+	tokenAccessorParameterSuffix      = "/Accessor"
+	tokenAccessorParameterDescription = "Vault Token Accessor"
 )
 
 // Create is invoked when the resource is created.
@@ -98,17 +133,61 @@ func (h *vaultTokenHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime
 
 	log.Printf("Vault Token `%s` - attempting to create", res.ParameterName)
 
-	return rid, res, res.doCreate()
+	return rid, res, res.doCreate(false)
 }
 
-// Update is invoked when the resource is updated.
+// Update is invoked when the resource is updated. A replacement token is minted with the new settings and either
+// overwrites the existing parameter or, when the parameter or token lineage changes, is created under a new
+// physical resource ID so that CloudFormation deletes (and possibly revokes) the old one. A lineage change under an
+// explicit ParameterName is refused, as both resources would share the parameter.
 func (h *vaultTokenHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
 	rid, res, err := h.resource(evt)
 	if err != nil {
 		return rid, nil, err
 	}
 
-	log.Printf("Vault Token `%s` - attempting to update (NOT IMPLEMENTED)", res.ParameterName)
+	old, err := h.properties(evt.OldResourceProperties, evt.StackID, rid)
+	if err != nil {
+		return rid, nil, err
+	}
+	old.vaultResource = res.vaultResource
+
+	if res.requiresReplacement(old) {
+		nrid := customresource.NewPhysicalResourceID(evt)
+		vault := res.vaultResource
+		if res, err = h.properties(evt.ResourceProperties, evt.StackID, nrid); err != nil {
+			return rid, nil, err
+		}
+		res.vaultResource = vault
+
+		// the old resource's delete would take an explicitly named parameter, shared with its replacement, with it
+		if res.ParameterName == old.ParameterName {
+			return rid, nil, fmt.Errorf("changing `Role` or `NoParent` of `%s` requires a new ParameterName", res.ParameterName)
+		}
+		rid = nrid
+
+		log.Printf("Vault Token `%s` - attempting to replace `%s`", res.ParameterName, old.ParameterName)
+
+		return rid, res, res.doCreate(false)
+	}
+
+	accessor, err := old.lookupAccessor()
+	if err != nil {
+		log.Printf("Vault Token `%s` - unable to lookup previous token: %v", old.ParameterName, err)
+	}
+
+	log.Printf("Vault Token `%s` - attempting to rotate", res.ParameterName)
+
+	if err = res.doCreate(true); err != nil {
+		return rid, nil, err
+	}
+
+	if res.RevokeOnDelete == "true" && accessor != "" {
+		log.Printf("Vault Token `%s` - attempting to revoke previous token", res.ParameterName)
+		if err = res.client.Auth().Token().RevokeAccessor(accessor); err != nil {
+			log.Printf("Vault Token `%s` - unable to revoke previous token: %v", res.ParameterName, err)
+		}
+	}
 
 	return rid, res, nil
 }
@@ -116,7 +195,7 @@ func (h *vaultTokenHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime
 // Delete is invoked when the resource is deleted.
 func (h *vaultTokenHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
 	_, res, err := h.resource(evt)
-	if err == nil && res.RevokeOnDelete == "true" && res.ParameterName != "" {
+	if err == nil && res.RevokeOnDelete == "true" {
 		res.client.SetMaxRetries(1)
 		res.client.SetClientTimeout(30 * time.Second)
 		log.Printf("Vault Token `%s` - delete with `RevokeOnDelete`", res.ParameterName)
@@ -124,13 +203,49 @@ func (h *vaultTokenHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime
 	}
 
 	if err != nil {
-		log.Printf("Vault Token `%s` - skipping delete: %v", res.ParameterName, err)
+		log.Printf("Vault Token `%s` - skipping revoke: %v", res.ParameterName, err)
+	}
+
+	if res != nil && res.DeleteParameter == "true" {
+		for _, name := range []string{res.ParameterName, res.AccessorParameterName} {
+			log.Printf("Vault Token `%s` - attempting to delete parameter `%s`", res.ParameterName, name)
+			if err = deleteParameter(name); err != nil {
+				log.Printf("Vault Token `%s` - skipping delete of parameter `%s`: %v", res.ParameterName, name, err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func (res *vaultTokenResource) doCreate() error {
+// requiresReplacement reports whether the token must be re-created under a new physical resource ID.
+func (res *vaultTokenResource) requiresReplacement(old *vaultTokenResource) bool {
+	return res.ParameterName != old.ParameterName || res.Role != old.Role || res.NoParent != old.NoParent
+}
+
+// lookupAccessor returns the accessor persisted alongside the token, falling back to looking up the token itself.
+func (res *vaultTokenResource) lookupAccessor() (string, error) {
+	accessor, _, err := getParameter(res.AccessorParameterName)
+	if err == nil && accessor != "" {
+		return accessor, nil
+	}
+	log.Printf("Vault Token `%s` - unable to read accessor parameter `%s`: %v", res.ParameterName, res.AccessorParameterName, err)
+
+	token, _, err := getParameter(res.ParameterName)
+	if err != nil {
+		return "", err
+	}
+
+	// DO NOT LOG THE RESPONSE
+	sec, err := res.client.Auth().Token().Lookup(token)
+	if err != nil {
+		return "", err
+	}
+
+	return sec.TokenAccessor()
+}
+
+func (res *vaultTokenResource) doCreate(overwrite bool) error {
 	var (
 		sec *vaultapi.Secret
 		err error
@@ -152,6 +267,14 @@ func (res *vaultTokenResource) doCreate() error {
 		NumUses:         int(useLimit),
 	}
 
+	// with a wrap TTL only the single-use wrapping token is stored, the consumer unwraps it to obtain the token
+	if res.WrapTTL != "" {
+		res.client.SetWrappingLookupFunc(func(string, string) string {
+			return res.WrapTTL
+		})
+		defer res.client.SetWrappingLookupFunc(nil)
+	}
+
 	if res.Role != "" {
 		sec, err = res.client.Auth().Token().CreateWithRole(tcr, res.Role)
 	} else if tcr.NoParent {
@@ -166,20 +289,47 @@ func (res *vaultTokenResource) doCreate() error {
 	if sec == nil {
 		return fmt.Errorf("somehow got a nil secret")
 	}
-	if sec.Auth == nil {
-		return fmt.Errorf("somehow got a nil secret.Auth")
+
+	var token, accessor string
+	if res.WrapTTL != "" {
+		if sec.WrapInfo == nil {
+			return fmt.Errorf("somehow got a nil secret.WrapInfo")
+		}
+		token = sec.WrapInfo.Token
+		accessor = sec.WrapInfo.WrappedAccessor
+	} else {
+		if sec.Auth == nil {
+			return fmt.Errorf("somehow got a nil secret.Auth")
+		}
+		token = sec.Auth.ClientToken
+		accessor = sec.Auth.Accessor
 	}
 
 	tpo := &parameterOptions{
 		Description:   res.ParameterDescription,
 		EncryptionKey: res.ParameterKey,
-		Overwrite:     false,
+		Overwrite:     overwrite,
 	}
-	if _, err = putParameter(tpo, res.ParameterName, sec.Auth.ClientToken); err != nil {
+	if _, err = putParameter(tpo, res.ParameterName, token); err != nil {
 		log.Printf("SSM PutParameter Error: %s", err)
 		return err
 	}
 
+	apo := &parameterOptions{
+		Description:   tokenAccessorParameterDescription,
+		EncryptionKey: res.ParameterKey,
+		Overwrite:     overwrite,
+	}
+	if _, err = putParameter(apo, res.AccessorParameterName, accessor); err != nil {
+		log.Printf("SSM PutParameter Error: %s", err)
+		return err
+	}
+
+	if sec.WrapInfo != nil {
+		res.WrappingAccessor = sec.WrapInfo.Accessor
+		return nil
+	}
+
 	res.Policies = sec.Auth.Policies
 	res.Renewable = fmt.Sprint(sec.Auth.Renewable)
 	res.Metadata = sec.Auth.Metadata
@@ -187,8 +337,27 @@ func (res *vaultTokenResource) doCreate() error {
 	return nil
 }
 
-func (res *vaultTokenResource) doRenew() error {
-	return fmt.Errorf("not implemented: token-renew")
+// doRenew renews a token read from the resource's parameter using the resource's own login, returning the token's
+// lookup afterwards and whether it was renewable at all.
+func (res *vaultTokenResource) doRenew(token string) (*vaultapi.Secret, bool, error) {
+	// DO NOT LOG THE RESPONSE
+	sec, err := res.client.Auth().Token().Lookup(token)
+	if err != nil {
+		return nil, false, err
+	}
+	if sec == nil || sec.Data == nil {
+		return nil, false, fmt.Errorf("somehow got a nil lookup for `%s`", res.ParameterName)
+	}
+	if renewable, _ := sec.Data["renewable"].(bool); !renewable {
+		return sec, false, nil
+	}
+
+	if _, err = res.client.Auth().Token().Renew(token, 0); err != nil {
+		return nil, true, err
+	}
+
+	sec, err = res.client.Auth().Token().Lookup(token)
+	return sec, true, err
 }
 
 func (res *vaultTokenResource) doRevoke() error {
@@ -196,11 +365,24 @@ func (res *vaultTokenResource) doRevoke() error {
 		return fmt.Errorf("RevokeOnDelete is not true")
 	}
 
+	// revoking an orphan leaves its children in place, which takes the token itself rather than its accessor
 	if res.NoParent == "true" {
-		log.Printf("Vault Token `%s` - attempting to revoke (orphan)", res.ParameterName)
-		return res.client.Auth().Token().RevokeOrphan(res.ParameterName)
+		if res.WrapTTL == "" {
+			token, _, err := getParameter(res.ParameterName)
+			if err != nil {
+				return err
+			}
+			log.Printf("Vault Token `%s` - attempting to revoke (orphan)", res.ParameterName)
+			return res.client.Auth().Token().RevokeOrphan(token)
+		}
+		log.Printf("Vault Token `%s` - only the wrapping token is stored, revoking its children too", res.ParameterName)
+	}
+
+	accessor, err := res.lookupAccessor()
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Vault Token `%s` - attempting to revoke (tree)", res.ParameterName)
-	return res.client.Auth().Token().RevokeTree(res.ParameterName)
+	log.Printf("Vault Token `%s` - attempting to revoke (tree) by accessor", res.ParameterName)
+	return res.client.Auth().Token().RevokeAccessor(accessor)
 }
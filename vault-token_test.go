@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+const testStackID = "arn:aws:cloudformation:us-east-1:123456789012:stack/test/00000000-0000-0000-0000-000000000000"
+
+// testVaultClient returns a client for the dev server in $VAULT_ADDR and $VAULT_TOKEN, e.g. from
+// `vault server -dev`, skipping the test when there is none.
+func testVaultClient(t *testing.T) *vaultapi.Client {
+	if os.Getenv("VAULT_ADDR") == "" || os.Getenv("VAULT_TOKEN") == "" {
+		t.Skip("$VAULT_ADDR and $VAULT_TOKEN of a dev server are required")
+	}
+
+	vcfg := vaultapi.DefaultConfig()
+	if err := vcfg.ReadEnvironment(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := vaultapi.NewClient(vcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// testParameters serves GetParameter, PutParameter and DeleteParameter from the given parameters in place of SSM,
+// returning a func that restores the real client.
+func testParameters(parameters map[string]string) func() {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		in := struct {
+			Name      string
+			Value     string
+			Overwrite bool
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fail := func(code string) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": code})
+		}
+
+		value, ok := parameters[in.Name]
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			if !ok {
+				fail("ParameterNotFound")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Parameter": map[string]interface{}{"Name": in.Name, "Value": value, "Version": 1},
+			})
+		case "AmazonSSM.PutParameter":
+			if ok && !in.Overwrite {
+				fail("ParameterAlreadyExists")
+				return
+			}
+			parameters[in.Name] = in.Value
+			json.NewEncoder(w).Encode(map[string]interface{}{"Version": 1})
+		case "AmazonSSM.DeleteParameter":
+			if !ok {
+				fail("ParameterNotFound")
+				return
+			}
+			delete(parameters, in.Name)
+			w.Write([]byte("{}"))
+		default:
+			http.Error(w, r.Header.Get("X-Amz-Target"), http.StatusNotImplemented)
+		}
+	}))
+
+	prev := simpleSystemsManager
+	simpleSystemsManager = ssm.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})))
+
+	return func() {
+		simpleSystemsManager = prev
+		srv.Close()
+	}
+}
+
+// testChildToken creates a child of the given token, returning its accessor.
+func testChildToken(t *testing.T, token string) string {
+	vcfg := vaultapi.DefaultConfig()
+	if err := vcfg.ReadEnvironment(); err != nil {
+		t.Fatal(err)
+	}
+	parent, err := vaultapi.NewClient(vcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.SetToken(token)
+
+	child, err := parent.Auth().Token().Create(&vaultapi.TokenCreateRequest{Policies: []string{"default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return child.Auth.Accessor
+}
+
+func TestTokenCreateDelete(t *testing.T) {
+	client := testVaultClient(t)
+
+	for _, tc := range []struct {
+		name         string
+		noParent     bool
+		childRevoked bool
+	}{
+		{name: "tree", noParent: false, childRevoked: true},
+		{name: "orphan", noParent: true, childRevoked: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parameters := map[string]string{}
+			restore := testParameters(parameters)
+			defer restore()
+
+			name := "/test/" + tokenParameterInfix + "/" + tc.name
+			props, _ := json.Marshal(map[string]interface{}{
+				"ParameterName":   name,
+				"Policies":        []string{"default"},
+				"NoParent":        fmt.Sprint(tc.noParent),
+				"RevokeOnDelete":  "true",
+				"DeleteParameter": "true",
+			})
+			evt := &cloudformation.Event{
+				RequestType:        "Create",
+				StackID:            testStackID,
+				LogicalResourceID:  "Token",
+				PhysicalResourceID: tc.name,
+				ResourceProperties: props,
+			}
+
+			if _, _, err := new(vaultTokenHandler).Create(evt, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			token, accessor := parameters[name], parameters[name+tokenAccessorParameterSuffix]
+			if token == "" || accessor == "" {
+				t.Fatalf("token parameters were not written: %v", parameters)
+			}
+			childAccessor := testChildToken(t, token)
+
+			evt.RequestType = "Delete"
+			if err := new(vaultTokenHandler).Delete(evt, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.Auth().Token().LookupAccessor(accessor); err == nil {
+				t.Errorf("token `%s` was not revoked", accessor)
+			}
+
+			_, err := client.Auth().Token().LookupAccessor(childAccessor)
+			if tc.childRevoked && err == nil {
+				t.Errorf("child token `%s` was not revoked", childAccessor)
+			}
+			if !tc.childRevoked && err != nil {
+				t.Errorf("child token `%s` was revoked: %v", childAccessor, err)
+			}
+
+			if len(parameters) != 0 {
+				t.Errorf("parameters were not deleted: %v", parameters)
+			}
+		})
+	}
+}
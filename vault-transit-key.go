@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
+)
+
+var (
+	// HandleTransitKeyRotation is the Lambda entrypoint for scheduled rotation of transit keys, meant to be triggered
+	// by a CloudWatch Events schedule with a constant input such as `{"Mount": "transit", "Names": ["default"]}`,
+	// or `$VAULT_TRANSIT_MOUNT` and a comma separated `$VAULT_TRANSIT_KEYS`.
+	HandleTransitKeyRotation func(json.RawMessage, *lambdaruntime.Context) (interface{}, error)
+)
+
+func init() {
+	customresource.Register("VaultTransitKey", new(vaultTransitKeyHandler))
+
+	HandleTransitKeyRotation = handleTransitKeyRotation
+}
+
+const (
+	transitDefaultMount = "transit"
+)
+
+type vaultTransitKeyHandler struct{}
+type vaultTransitKeyResource struct {
+	vaultResource `json:"-"`
+
+	Mount string `json:",omitempty"`
+	Name  string `json:",omitempty"`
+
+	// fixed when the key is created
+	Type                 string `json:",omitempty"`
+	Derived              string `json:",omitempty"`
+	ConvergentEncryption string `json:",omitempty"`
+
+	Exportable           string `json:",omitempty"`
+	MinDecryptionVersion string `json:",omitempty"`
+	MinEncryptionVersion string `json:",omitempty"`
+	DeletionAllowed      string `json:",omitempty"`
+
+	// change to rotate the key on update
+	Rotation string `json:",omitempty"`
+
+	LatestVersion string `json:",omitempty"`
+}
+
+func (h *vaultTransitKeyHandler) resource(evt *cloudformation.Event) (string, *vaultTransitKeyResource, error) {
+	rid := resourceID(evt)
+	res := &vaultTransitKeyResource{}
+
+	if err := json.Unmarshal(evt.ResourceProperties, res); err != nil {
+		return rid, nil, err
+	}
+
+	if err := res.validate(); err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.initWithLogin(evt.ResourceProperties)
+}
+
+func (res *vaultTransitKeyResource) validate() error {
+	res.Mount = strings.Trim(res.Mount, "/")
+	if res.Mount == "" {
+		res.Mount = transitDefaultMount
+	}
+
+	if res.Name == "" {
+		return errors.New("missing required resource property `Name`")
+	}
+
+	for name, value := range map[string]*string{
+		"Derived":              &res.Derived,
+		"ConvergentEncryption": &res.ConvergentEncryption,
+		"Exportable":           &res.Exportable,
+		"DeletionAllowed":      &res.DeletionAllowed,
+	} {
+		if *value == "" {
+			*value = "false"
+			continue
+		}
+		b, err := strconv.ParseBool(*value)
+		if err != nil {
+			return fmt.Errorf("invalid `%s`: %v", name, err)
+		}
+		*value = fmt.Sprint(b)
+	}
+
+	if res.ConvergentEncryption == "true" && res.Derived != "true" {
+		return errors.New("`ConvergentEncryption` requires `Derived`")
+	}
+
+	for name, version := range map[string]string{
+		"MinDecryptionVersion": res.MinDecryptionVersion,
+		"MinEncryptionVersion": res.MinEncryptionVersion,
+	} {
+		if version == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(version, 10, 64); err != nil {
+			return fmt.Errorf("invalid `%s`: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Create is invoked when the resource is created.
+func (h *vaultTransitKeyHandler) Create(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.doCreate()
+}
+
+// Update is invoked when the resource is updated.
+func (h *vaultTransitKeyHandler) Update(evt *cloudformation.Event, ctx *lambdaruntime.Context) (string, interface{}, error) {
+	rid, res, err := h.resource(evt)
+	if err != nil {
+		return rid, nil, err
+	}
+
+	old := &vaultTransitKeyResource{}
+	if err = json.Unmarshal(evt.OldResourceProperties, old); err != nil {
+		return rid, nil, err
+	}
+	if err = old.validate(); err != nil {
+		return rid, nil, err
+	}
+
+	// a different key is a new resource, leaving the old one to be deleted, or not, by its own rules
+	if res.Mount != old.Mount || res.Name != old.Name {
+		return customresource.NewPhysicalResourceID(evt), res, res.doCreate()
+	}
+
+	if res.Type != "" && res.Type != old.Type {
+		// an explicit type may only name the one the key was created with
+		cur := &vaultTransitKeyResource{vaultResource: res.vaultResource, Mount: res.Mount, Name: res.Name}
+		if err = cur.doRead(); err != nil {
+			return rid, nil, err
+		}
+		if cur.Type != res.Type {
+			return rid, nil, fmt.Errorf("`Type` cannot be changed from %s on an existing key", cur.Type)
+		}
+	}
+
+	if res.Derived != old.Derived || res.ConvergentEncryption != old.ConvergentEncryption {
+		return rid, nil, errors.New("`Derived` and `ConvergentEncryption` cannot be changed on an existing key")
+	}
+
+	if res.Rotation != old.Rotation {
+		log.Printf("Vault Transit Key `%s` - attempting rotate", res.keyPath())
+		if _, err = res.client.Logical().Write(res.keyPath()+"/rotate", nil); err != nil {
+			return rid, nil, err
+		}
+	}
+
+	if err = res.doConfig(); err != nil {
+		return rid, nil, err
+	}
+
+	return rid, res, res.doRead()
+}
+
+// Delete is invoked when the resource is deleted.
+func (h *vaultTransitKeyHandler) Delete(evt *cloudformation.Event, ctx *lambdaruntime.Context) error {
+	_, res, err := h.resource(evt)
+	if err == nil {
+		res.client.SetMaxRetries(1)
+		res.client.SetClientTimeout(30 * time.Second)
+		// a key that was never created, or is already gone, is nothing to refuse
+		err = res.doRead()
+	}
+
+	if err == nil && res.DeletionAllowed != "true" {
+		// every ciphertext under the key becomes useless, so this is the template's call to make
+		return fmt.Errorf("refusing to delete transit key `%s` without `DeletionAllowed`, or set `RetainOnDelete` to leave it in place", res.keyPath())
+	}
+
+	if err == nil {
+		if err = res.doConfig(); err == nil {
+			log.Printf("Vault Transit Key `%s` - attempting delete", res.keyPath())
+			_, err = res.client.Logical().Delete(res.keyPath())
+		}
+	}
+
+	if err != nil {
+		log.Printf("Vault Transit Key - skipping delete: %v", err)
+	}
+
+	return nil
+}
+
+func (res *vaultTransitKeyResource) keyPath() string {
+	return fmt.Sprintf("%s/keys/%s", res.Mount, res.Name)
+}
+
+func (res *vaultTransitKeyResource) doCreate() error {
+	data := map[string]interface{}{
+		"derived":               res.Derived == "true",
+		"convergent_encryption": res.ConvergentEncryption == "true",
+		"exportable":            res.Exportable == "true",
+	}
+	if res.Type != "" {
+		data["type"] = res.Type
+	}
+
+	log.Printf("Vault Transit Key `%s` - attempting create", res.keyPath())
+	if _, err := res.client.Logical().Write(res.keyPath(), data); err != nil {
+		return err
+	}
+
+	if err := res.doConfig(); err != nil {
+		return err
+	}
+
+	return res.doRead()
+}
+
+func (res *vaultTransitKeyResource) doConfig() error {
+	data := map[string]interface{}{
+		"deletion_allowed": res.DeletionAllowed == "true",
+	}
+	// exportability cannot be taken back
+	if res.Exportable == "true" {
+		data["exportable"] = true
+	}
+	if res.MinDecryptionVersion != "" {
+		data["min_decryption_version"], _ = strconv.ParseUint(res.MinDecryptionVersion, 10, 64)
+	}
+	if res.MinEncryptionVersion != "" {
+		data["min_encryption_version"], _ = strconv.ParseUint(res.MinEncryptionVersion, 10, 64)
+	}
+
+	log.Printf("Vault Transit Key `%s` - attempting config", res.keyPath())
+	_, err := res.client.Logical().Write(res.keyPath()+"/config", data)
+
+	return err
+}
+
+// doRead reads back the key's type and latest version as attributes.
+func (res *vaultTransitKeyResource) doRead() error {
+	sec, err := res.client.Logical().Read(res.keyPath())
+	if err != nil {
+		return err
+	}
+	if sec == nil || sec.Data == nil {
+		return fmt.Errorf("transit key `%s` not found", res.keyPath())
+	}
+
+	res.Type, _ = sec.Data["type"].(string)
+	res.LatestVersion = fmt.Sprint(int64Value(sec.Data["latest_version"]))
+
+	return nil
+}
+
+type transitKeyRotationRequest struct {
+	Mount string   `json:",omitempty"`
+	Names []string `json:",omitempty"`
+}
+
+func handleTransitKeyRotation(evt json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	req := &transitKeyRotationRequest{
+		Mount: os.Getenv("VAULT_TRANSIT_MOUNT"),
+	}
+	if keys := os.Getenv("VAULT_TRANSIT_KEYS"); keys != "" {
+		req.Names = strings.Split(keys, ",")
+	}
+	if err := json.Unmarshal(evt, req); err != nil {
+		log.Printf("Vault Transit Key Rotation - ignoring unparseable event: %v", err)
+	}
+
+	if len(req.Names) == 0 {
+		return nil, errors.New("missing required `Names` (or $VAULT_TRANSIT_KEYS)")
+	}
+
+	res := &vaultTransitKeyResource{Mount: strings.Trim(req.Mount, "/")}
+	if res.Mount == "" {
+		res.Mount = transitDefaultMount
+	}
+	if err := res.initWithLogin(nil); err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	versions := make(map[string]string, len(req.Names))
+	for _, name := range req.Names {
+		res.Name = strings.TrimSpace(name)
+		log.Printf("Vault Transit Key `%s` - attempting rotate", res.keyPath())
+		_, err := res.client.Logical().Write(res.keyPath()+"/rotate", nil)
+		if err == nil {
+			err = res.doRead()
+		}
+		if err != nil {
+			log.Printf("Vault Transit Key `%s` - Rotate: %v", res.keyPath(), err)
+			failed = append(failed, res.Name)
+			continue
+		}
+		versions[res.Name] = res.LatestVersion
+	}
+
+	if len(failed) > 0 {
+		return versions, fmt.Errorf("failed to rotate %d key(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+var (
+	// HandleLifecycleUnseal is the Lambda entrypoint for autoscaling `EC2_INSTANCE_LAUNCHING` lifecycle actions,
+	// delivered via CloudWatch Events. It waits for the new instance's Vault to respond, unseals it with the shards
+	// under `$VAULT_SECRET_SHARE_PARAMETER` and completes the lifecycle action, abandoning it when that fails. A node
+	// that has not responded by the Lambda's timeout leaves the action pending, heartbeating it and re-invoking.
+	HandleLifecycleUnseal func(json.RawMessage, *lambdaruntime.Context) (interface{}, error)
+
+	// HandleUnsealSweep is the Lambda entrypoint for a CloudWatch Events schedule. It checks the health of every
+	// running instance in `$VAULT_SERVER_GROUP` in parallel and unseals any node that is initialized but sealed.
+	HandleUnsealSweep func(json.RawMessage, *lambdaruntime.Context) (interface{}, error)
+
+	errUnsealDeadline = errors.New("deadline exceeded waiting for vault to respond")
+)
+
+func init() {
+	HandleLifecycleUnseal = handleLifecycleUnseal
+	HandleUnsealSweep = handleUnsealSweep
+}
+
+const (
+	lifecycleTransitionLaunching = "autoscaling:EC2_INSTANCE_LAUNCHING"
+
+	lifecycleActionContinue = "CONTINUE"
+	lifecycleActionAbandon  = "ABANDON"
+
+	// time reserved for completing the lifecycle action before the Lambda times out
+	unsealDeadlineMargin = 5 * time.Second
+	unsealHealthInterval = 5 * time.Second
+)
+
+type lifecycleAction struct {
+	AutoScalingGroupName string
+	LifecycleHookName    string
+	LifecycleActionToken string
+	LifecycleTransition  string
+	EC2InstanceId        string
+}
+
+type lifecycleEvent struct {
+	Detail *lifecycleAction `json:"detail"`
+}
+
+// newUnsealResource configures a resource from the environment, for entrypoints outside of CloudFormation.
+func newUnsealResource() (*vaultInitResource, error) {
+	res := &vaultInitResource{
+		ServerScheme:             os.Getenv("VAULT_SERVER_SCHEME"),
+		ServerGroup:              os.Getenv("VAULT_SERVER_GROUP"),
+		ServerPort:               os.Getenv("VAULT_SERVER_PORT"),
+		SecretShareParameterName: os.Getenv("VAULT_SECRET_SHARE_PARAMETER"),
+	}
+
+	if res.ServerScheme == "" {
+		res.ServerScheme = initDefaultScheme
+	}
+	if res.ServerPort == "" {
+		res.ServerPort = initDefaultPort
+	}
+	if res.SecretShareParameterName == "" {
+		return nil, errors.New("missing required $VAULT_SECRET_SHARE_PARAMETER")
+	}
+
+	if err := res.init(); err != nil {
+		return nil, err
+	}
+
+	// override default retry and timeout configuration to give us more control over timing
+	res.client.SetMaxRetries(0)
+	res.client.SetClientTimeout(unsealHealthInterval)
+
+	return res, nil
+}
+
+func handleLifecycleUnseal(evt json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	until := deadline(ctx, unsealDeadlineMargin)
+
+	wrapper := &lifecycleEvent{}
+	if err := json.Unmarshal(evt, wrapper); err != nil {
+		return nil, err
+	}
+	action := wrapper.Detail
+	if action == nil {
+		// invoked directly with the lifecycle action
+		action = &lifecycleAction{}
+		if err := json.Unmarshal(evt, action); err != nil {
+			return nil, err
+		}
+	}
+
+	if action.LifecycleTransition != lifecycleTransitionLaunching {
+		log.Printf("Vault Unseal - ignoring lifecycle transition `%s`", action.LifecycleTransition)
+		return nil, nil
+	}
+
+	res, err := newUnsealResource()
+	if err != nil {
+		return nil, err
+	}
+
+	result := lifecycleActionContinue
+	status, err := res.doLifecycleUnseal(action.EC2InstanceId, until)
+	if err == errUnsealDeadline {
+		// a node still booting is not a failure, keep the action pending and carry on in another invocation
+		return status, res.doLifecycleContinue(action, evt, ctx)
+	}
+	if err != nil {
+		// a node left sealed must not go into service
+		log.Printf("Vault Unseal `%s`: %v", action.EC2InstanceId, err)
+		result = lifecycleActionAbandon
+	}
+
+	log.Printf("Vault Unseal `%s` - completing lifecycle action: %s", action.EC2InstanceId, result)
+	if cerr := completeLifecycleAction(action.AutoScalingGroupName, action.LifecycleHookName, action.LifecycleActionToken, action.EC2InstanceId, result); cerr != nil {
+		return status, cerr
+	}
+
+	return status, err
+}
+
+// doLifecycleContinue extends a pending lifecycle action and re-invokes the function with the same event. Once the
+// action has run its course the heartbeat fails, and the hook's default result applies.
+func (res *vaultInitResource) doLifecycleContinue(action *lifecycleAction, evt json.RawMessage, ctx *lambdaruntime.Context) error {
+	log.Printf("Vault Unseal `%s` - recording lifecycle action heartbeat", action.EC2InstanceId)
+	if err := recordLifecycleActionHeartbeat(action.AutoScalingGroupName, action.LifecycleHookName, action.LifecycleActionToken, action.EC2InstanceId); err != nil {
+		return fmt.Errorf("%v, unable to record lifecycle action heartbeat: %v", errUnsealDeadline, err)
+	}
+
+	if ctx == nil || ctx.InvokedFunctionARN == "" {
+		return fmt.Errorf("%v, unable to re-invoke without a function ARN", errUnsealDeadline)
+	}
+
+	log.Printf("Vault Unseal `%s` - attempting re-invocation of `%s`", action.EC2InstanceId, ctx.InvokedFunctionARN)
+	return invokeFunctionAsync(ctx.InvokedFunctionARN, evt)
+}
+
+func (res *vaultInitResource) doLifecycleUnseal(instance string, until time.Time) (*vaultapi.SealStatusResponse, error) {
+	iip, err := getInstanceAddress(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("%s://%s:%s", res.ServerScheme, iip, res.ServerPort)
+	if err = res.client.SetAddress(addr); err != nil {
+		return nil, err
+	}
+
+	for {
+		health, err := res.client.Sys().Health()
+		if err == nil {
+			log.Printf("Vault Unseal `%s` - Health: %+v", addr, health)
+			if !health.Initialized {
+				return nil, fmt.Errorf("`%s` is not initialized", addr)
+			}
+			if !health.Sealed {
+				return nil, nil
+			}
+			break
+		}
+		log.Printf("Vault Unseal `%s` - Health: %v", addr, err)
+		if time.Now().Add(unsealHealthInterval).After(until) {
+			return nil, errUnsealDeadline
+		}
+		time.Sleep(unsealHealthInterval)
+	}
+
+	shares, err := readShareParameters(res.SecretShareParameterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.doUnseal(res.client, addr, shares)
+}
+
+type nodeState struct {
+	Address     string
+	Initialized bool
+	Sealed      bool
+	Standby     bool
+	Unsealed    bool   `json:",omitempty"`
+	Error       string `json:",omitempty"`
+}
+
+func handleUnsealSweep(evt json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	res, err := newUnsealResource()
+	if err != nil {
+		return nil, err
+	}
+	if res.ServerGroup == "" {
+		return nil, errors.New("missing required $VAULT_SERVER_GROUP")
+	}
+
+	instanceAddr, err := listInstanceAddressesInGroup(res.ServerGroup)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(instanceAddr)
+
+	states := make([]*nodeState, len(instanceAddr))
+	for i, iip := range instanceAddr {
+		states[i] = &nodeState{
+			Address: fmt.Sprintf("%s://%s:%s", res.ServerScheme, iip, res.ServerPort),
+		}
+	}
+
+	res.doSweep(states, func(state *nodeState, client *vaultapi.Client) {
+		health, err := client.Sys().Health()
+		if err != nil {
+			state.Error = err.Error()
+			return
+		}
+		state.Initialized = health.Initialized
+		state.Sealed = health.Sealed
+		state.Standby = health.Standby
+	})
+
+	sealed := 0
+	for _, state := range states {
+		if state.Initialized && state.Sealed {
+			sealed++
+		}
+	}
+
+	if sealed > 0 {
+		shares, err := readShareParameters(res.SecretShareParameterName)
+		if err != nil {
+			return states, err
+		}
+		res.doSweep(states, func(state *nodeState, client *vaultapi.Client) {
+			if !state.Initialized || !state.Sealed {
+				return
+			}
+			status, err := res.doUnseal(client, state.Address, shares)
+			if err != nil {
+				state.Error = err.Error()
+				return
+			}
+			state.Sealed = status.Sealed
+			state.Unsealed = true
+		})
+	}
+
+	failed := []string{}
+	for _, state := range states {
+		log.Printf("Vault Unseal Sweep `%s`: Initialized:%t, Sealed:%t, Standby:%t, Unsealed:%t, Error:%s",
+			state.Address, state.Initialized, state.Sealed, state.Standby, state.Unsealed, state.Error)
+		if state.Error != "" {
+			failed = append(failed, state.Address)
+		}
+	}
+
+	if len(failed) > 0 {
+		return states, fmt.Errorf("unable to sweep %d node(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return states, nil
+}
+
+// doSweep runs fn concurrently for every node, each with its own client.
+func (res *vaultInitResource) doSweep(states []*nodeState, fn func(*nodeState, *vaultapi.Client)) {
+	var wg sync.WaitGroup
+	for _, state := range states {
+		client, err := res.newClientForAddress(state.Address)
+		if err != nil {
+			state.Error = err.Error()
+			continue
+		}
+		client.SetMaxRetries(0)
+		client.SetClientTimeout(unsealHealthInterval)
+
+		wg.Add(1)
+		go func(state *nodeState, client *vaultapi.Client) {
+			defer wg.Done()
+			fn(state, client)
+		}(state, client)
+	}
+	wg.Wait()
+}
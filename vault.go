@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	customresource "github.com/eawsy/aws-cloudformation-go-customres/service/cloudformation/customres"
+	lambdaruntime "github.com/eawsy/aws-lambda-go-core/service/lambda/runtime"
 	cloudformation "github.com/eawsy/aws-lambda-go-event/service/lambda/runtime/event/cloudformationevt"
 	vaultapi "github.com/hashicorp/vault/api"
 )
@@ -15,11 +20,33 @@ var (
 )
 
 func init() {
-	Handle = customresource.HandleLambda
+	Handle = handle
 }
 
 type vaultHandler struct{}
 
+// lifecycleProperties are honoured for every resource type, ahead of its handler.
+type lifecycleProperties struct {
+	// leaves whatever the resource created in place when it is deleted
+	RetainOnDelete string `json:",omitempty"`
+}
+
+func handle(raw json.RawMessage, ctx *lambdaruntime.Context) (interface{}, error) {
+	evt := &cloudformation.Event{}
+	if err := json.Unmarshal(raw, evt); err == nil && evt.RequestType == "Delete" {
+		props := &lifecycleProperties{}
+		if err = json.Unmarshal(evt.ResourceProperties, props); err != nil {
+			log.Printf("failed to parse lifecycle properties: %v", err)
+		}
+		if retain, _ := strconv.ParseBool(props.RetainOnDelete); retain {
+			log.Printf("%s `%s` - retaining on delete", evt.ResourceType, evt.LogicalResourceID)
+			return nil, sendResponse(evt, ctx, evt.PhysicalResourceID, nil, nil)
+		}
+	}
+
+	return handleContinuable(raw, ctx)
+}
+
 func resourceID(evt *cloudformation.Event) string {
 	if evt.PhysicalResourceID == "" {
 		return customresource.NewPhysicalResourceID(evt)
@@ -47,6 +74,26 @@ func (res *vaultResource) init() error {
 	return nil
 }
 
+// newClientForAddress returns a new client, configured like the resource's own, talking to a specific server.
+func (res *vaultResource) newClientForAddress(addr string) (*vaultapi.Client, error) {
+	vcfg := vaultapi.DefaultConfig()
+
+	if verr := vcfg.ReadEnvironment(); verr != nil {
+		return nil, verr
+	}
+	vcfg.Address = addr
+
+	vapi, verr := vaultapi.NewClient(vcfg)
+	if verr != nil {
+		return nil, verr
+	}
+	if res.client != nil {
+		vapi.SetToken(res.client.Token())
+	}
+
+	return vapi, nil
+}
+
 func (res *vaultResource) initWithTokenParameterOverride() error {
 	if err := res.init(); err != nil {
 		return err
@@ -68,6 +115,28 @@ func (res *vaultResource) initWithTokenParameterOverride() error {
 	return nil
 }
 
+// deadline returns when the invocation must wrap up, leaving margin before the Lambda's own timeout.
+func deadline(ctx *lambdaruntime.Context, margin time.Duration) time.Time {
+	if ctx == nil || ctx.RemainingTimeInMillis == nil {
+		return time.Now().Add(time.Minute - margin)
+	}
+	return time.Now().Add(time.Duration(ctx.RemainingTimeInMillis())*time.Millisecond - margin)
+}
+
+// validateTTL checks that a TTL property is empty, a number of seconds, or a duration string such as `768h`.
+func validateTTL(name, ttl string) error {
+	if ttl == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(ttl, 10, 64); err == nil {
+		return nil
+	}
+	if _, err := time.ParseDuration(ttl); err != nil {
+		return fmt.Errorf("invalid `%s`: %v", name, err)
+	}
+	return nil
+}
+
 // Happy IDE means happy developer.
 func main() {
 }